@@ -9,4 +9,6 @@ type Config struct {
 	HttpPort           string
 	FeishuAppID        string
 	FeishuAppSecret    string
+	// AccessControlMaxCountPerUserPerDay limits the questions of a user per day, 0 means unlimited
+	AccessControlMaxCountPerUserPerDay int
 }
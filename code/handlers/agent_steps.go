@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"start-feishubot/services/ai"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/services/core"
+	"strconv"
+	"strings"
+)
+
+// agentSteps collects the thoughts and tool calls of an agent answer as
+// they stream in. Dify resends a step with the same ID when it changes.
+type agentSteps struct {
+	mode  core.AgentThoughtsMode
+	ids   []string
+	steps map[string]*cardtemplate.AgentStep
+}
+
+func newAgentSteps(mode core.AgentThoughtsMode) *agentSteps {
+	return &agentSteps{
+		mode:  mode,
+		steps: make(map[string]*cardtemplate.AgentStep),
+	}
+}
+
+// apply records a thought or tool call event and reports whether the
+// steps shown on the card changed
+func (s *agentSteps) apply(event ai.StreamEvent) bool {
+	if s.mode == core.AgentThoughtsHidden {
+		return false
+	}
+
+	switch e := event.(type) {
+	case ai.ThoughtEvent:
+		step := s.step(e.ID, e.Position)
+		if step.Thought == e.Thought {
+			return false
+		}
+		step.Thought = e.Thought
+		return true
+
+	case ai.ToolCallEvent:
+		step := s.step(e.ID, e.Position)
+		if step.Tool == e.Tool && step.Input == e.Input && step.Observation == e.Observation {
+			return false
+		}
+		step.Tool = e.Tool
+		step.Input = e.Input
+		step.Observation = e.Observation
+		return true
+	}
+	return false
+}
+
+// step returns the step of the ID, adding it in arrival order when new
+func (s *agentSteps) step(id string, position int) *cardtemplate.AgentStep {
+	if id == "" {
+		id = "#" + strconv.Itoa(position)
+	}
+	if step, ok := s.steps[id]; ok {
+		return step
+	}
+	step := &cardtemplate.AgentStep{}
+	s.ids = append(s.ids, id)
+	s.steps[id] = step
+	return step
+}
+
+// answer returns the card content of the answer text below prefix with the
+// steps. The last thought of an agent repeats the answer, such steps are skipped.
+func (s *agentSteps) answer(prefix string, text string) cardtemplate.Answer {
+	answer := cardtemplate.Answer{
+		Body:        prefix + text,
+		ExpandSteps: s.mode == core.AgentThoughtsExpanded,
+	}
+	for _, id := range s.ids {
+		step := s.steps[id]
+		if step.Tool == "" && repeatsAnswer(step.Thought, text) {
+			continue
+		}
+		answer.Steps = append(answer.Steps, *step)
+	}
+	return answer
+}
+
+// repeatsAnswer reports whether the thought is the answer text streamed so far
+func repeatsAnswer(thought string, text string) bool {
+	thought = strings.TrimSpace(thought)
+	text = strings.TrimSpace(text)
+	if thought == "" {
+		return true
+	}
+	if text == "" {
+		return false
+	}
+	return strings.HasPrefix(thought, text) || strings.Contains(text, thought)
+}
+
+// getAgentThoughtsMode returns the configured agent thoughts mode, collapsed by default
+func getAgentThoughtsMode() core.AgentThoughtsMode {
+	if globalConfig == nil || globalConfig.GetAgentThoughts() == "" {
+		return core.AgentThoughtsCollapsed
+	}
+	return core.AgentThoughtsMode(globalConfig.GetAgentThoughts())
+}
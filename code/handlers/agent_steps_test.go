@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"reflect"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/services/core"
+	"testing"
+)
+
+func TestAgentSteps(t *testing.T) {
+	events := []ai.StreamEvent{
+		ai.ThoughtEvent{ID: "th1", Position: 1, Thought: "先搜索一下"},
+		ai.ToolCallEvent{ID: "th1", Position: 1, Tool: "search", Input: `{"query": "dify"}`},
+		ai.ToolCallEvent{ID: "th1", Position: 1, Tool: "search", Input: `{"query": "dify"}`, Observation: "Dify is an LLM app platform"},
+		// 最后一步的思考即为回答内容
+		ai.ThoughtEvent{ID: "th2", Position: 2, Thought: "Dify是LLM应用平台"},
+	}
+
+	tests := []struct {
+		name    string
+		mode    core.AgentThoughtsMode
+		changed []bool
+		want    cardtemplate.Answer
+	}{
+		{
+			name:    "Collapsed",
+			mode:    core.AgentThoughtsCollapsed,
+			changed: []bool{true, true, true, true},
+			want: cardtemplate.Answer{
+				Body: "🎤 什么是Dify\n\nDify是LLM应用平台",
+				Steps: []cardtemplate.AgentStep{
+					{Thought: "先搜索一下", Tool: "search", Input: `{"query": "dify"}`, Observation: "Dify is an LLM app platform"},
+				},
+			},
+		},
+		{
+			name:    "Expanded",
+			mode:    core.AgentThoughtsExpanded,
+			changed: []bool{true, true, true, true},
+			want: cardtemplate.Answer{
+				Body: "🎤 什么是Dify\n\nDify是LLM应用平台",
+				Steps: []cardtemplate.AgentStep{
+					{Thought: "先搜索一下", Tool: "search", Input: `{"query": "dify"}`, Observation: "Dify is an LLM app platform"},
+				},
+				ExpandSteps: true,
+			},
+		},
+		{
+			name:    "Hidden",
+			mode:    core.AgentThoughtsHidden,
+			changed: []bool{false, false, false, false},
+			want:    cardtemplate.Answer{Body: "🎤 什么是Dify\n\nDify是LLM应用平台"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := newAgentSteps(tt.mode)
+			for i, event := range events {
+				if got := steps.apply(event); got != tt.changed[i] {
+					t.Errorf("apply(%#v) = %v, want %v", event, got, tt.changed[i])
+				}
+			}
+			// 重复的事件不触发更新
+			if steps.apply(events[2]) {
+				t.Errorf("apply() of an unchanged step = true, want false")
+			}
+
+			got := steps.answer("🎤 什么是Dify\n\n", "Dify是LLM应用平台")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepeatsAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		thought string
+		text    string
+		want    bool
+	}{
+		{name: "Empty thought", thought: " ", text: "答案", want: true},
+		{name: "Answer not started", thought: "先搜索", text: "", want: false},
+		{name: "Answer streaming", thought: "Dify是LLM应用平台", text: "Dify是", want: true},
+		{name: "Answer finished", thought: "Dify是LLM应用平台", text: "Dify是LLM应用平台\n", want: true},
+		{name: "Different thought", thought: "先搜索", text: "Dify是", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repeatsAnswer(tt.thought, tt.text); got != tt.want {
+				t.Errorf("repeatsAnswer(%q, %q) = %v, want %v", tt.thought, tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -26,5 +26,5 @@ func CommonProcessClearCache(
 	}
 
 	sessionCache.Clear(cardMsg.SessionId)
-	return nil, nil
+	return newToastResp(ToastSuccess, "已清除当前会话的上下文"), nil
 }
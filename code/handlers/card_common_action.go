@@ -40,4 +40,30 @@ var cardHandlerMap = CardHandlerMap{
 			return CommonProcessRole(ctx, cardAction, m.sessionCache, cardMsg.SessionId, cardMsg.MsgId)
 		}
 	},
+	SuggestedQuestionKind: func(cardMsg CardMsg, m *MessageHandler) CardHandlerFunc {
+		return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+			return CommonProcessSuggestedQuestion(ctx, cardAction, m, cardMsg)
+		}
+	},
+}
+
+// Toast types for card action responses
+const (
+	ToastInfo    = "info"
+	ToastSuccess = "success"
+	ToastError   = "error"
+	ToastWarning = "warning"
+)
+
+// newToastResp builds a toast response for a card action
+func newToastResp(toastType string, content string) *larkcard.CustomResp {
+	return &larkcard.CustomResp{
+		StatusCode: 200,
+		Body: map[string]interface{}{
+			"toast": map[string]interface{}{
+				"type":    toastType,
+				"content": content,
+			},
+		},
+	}
 }
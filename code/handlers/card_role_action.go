@@ -3,7 +3,12 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"log"
+	"start-feishubot/initialization"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/cardtemplate"
 	"start-feishubot/services/core"
 )
 
@@ -25,8 +30,17 @@ func CommonProcessRoleTag(
 		return nil, err
 	}
 
-	// Process role tag
-	return nil, nil
+	// 下拉菜单选中的标签
+	tag := cardAction.Action.Option
+	if tag == "" {
+		return newToastResp(ToastError, "请选择角色分类"), nil
+	}
+	titles := initialization.GetTitleListByTag(tag)
+	if titles == nil || len(*titles) == 0 {
+		return newToastResp(ToastError, fmt.Sprintf("分类 %s 下没有角色", tag)), nil
+	}
+
+	return newRoleListCard(cardMsg.SessionId, cardMsg.ChatType, tag, *titles), nil
 }
 
 func CommonProcessRole(
@@ -47,6 +61,42 @@ func CommonProcessRole(
 		return nil, err
 	}
 
-	// Process role
-	return nil, nil
+	// 下拉菜单选中的角色
+	title := cardAction.Action.Option
+	role := initialization.GetRoleByTitle(title)
+	if role == nil {
+		return newToastResp(ToastError, fmt.Sprintf("角色 %s 不存在", title)), nil
+	}
+
+	applyRole(sessionCache, cardMsg.SessionId, role)
+	return newToastResp(ToastSuccess, fmt.Sprintf("已切换角色: %s", role.Title)), nil
+}
+
+// applyRole resets the session and sets the role content as its system prompt.
+// Clearing the session drops its Dify conversation, so the next message
+// starts a new conversation that begins with the prompt.
+func applyRole(sessionCache core.SessionCache, sessionId string, role *initialization.Role) {
+	sessionCache.Clear(sessionId)
+	sessionCache.SetMsg(sessionId, []ai.Message{
+		{
+			Role:    "system",
+			Content: role.Content,
+		},
+	})
+	log.Printf("[Handlers] Applied role %s to session %s", role.Title, sessionId)
+}
+
+// newRoleTagCard builds a card to choose a role tag
+func newRoleTagCard(cardMsg CardMsg, tags []string) *larkcard.MessageCard {
+	return cardtemplate.RolePicker("🛖 请选择角色类别", "选择分类后可以查看该分类下的内置角色", cardMsg, tags)
+}
+
+// newRoleListCard builds a card to choose a role under a tag
+func newRoleListCard(sessionId string, chatType CardChatType, tag string, titles []string) *larkcard.MessageCard {
+	return cardtemplate.RolePicker("🛖 选择内置角色", fmt.Sprintf("分类: **%s**", tag), CardMsg{
+		Kind:      RoleChooseKind,
+		ChatType:  chatType,
+		SessionId: sessionId,
+		Value:     tag,
+	}, titles)
 }
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"log"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/cardtemplate"
+	"strings"
+	"time"
+)
+
+// MaxSuggestedQuestions is the number of follow-up buttons on an answer card
+const MaxSuggestedQuestions = 3
+
+// suggestFollowUps fetches the follow-up questions of a finished answer as
+// card buttons. Providers without suggestions or failures yield none.
+func suggestFollowUps(ctx context.Context, handler *MessageHandler, info *MsgInfo, messageID string) []cardtemplate.Suggestion {
+	suggester, ok := handler.dify.(ai.Suggester)
+	if !ok || messageID == "" {
+		return nil
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	// Dify按user查找消息，需与提问时的user一致
+	questions, err := suggester.SuggestedQuestions(fetchCtx, *info.sessionId, messageID)
+	if err != nil {
+		log.Printf("Failed to get suggested questions of message %s: %v", messageID, err)
+		return nil
+	}
+
+	var suggestions []cardtemplate.Suggestion
+	for _, question := range questions {
+		question = strings.TrimSpace(question)
+		if question == "" {
+			continue
+		}
+		suggestions = append(suggestions, cardtemplate.Suggestion{
+			Question: question,
+			Msg:      NewCardMsg(SuggestedQuestionKind, info, question),
+		})
+		if len(suggestions) == MaxSuggestedQuestions {
+			break
+		}
+	}
+	return suggestions
+}
+
+// CommonProcessSuggestedQuestion asks the clicked question in the session of
+// the answer card, as if the user had sent it
+func CommonProcessSuggestedQuestion(
+	ctx context.Context,
+	cardAction *larkcard.CardAction,
+	m *MessageHandler,
+	cardMsg CardMsg,
+) (interface{}, error) {
+	question, _ := cardMsg.Value.(string)
+	question = strings.TrimSpace(question)
+	if question == "" || cardMsg.SessionId == "" {
+		return newToastResp(ToastError, "问题已失效，请直接发送消息提问"), nil
+	}
+
+	// 回复点击的卡片，话题中的回复仍留在同一话题
+	replyTo := cardAction.OpenMessageID
+	if replyTo == "" {
+		replyTo = cardMsg.MsgId
+	}
+	if replyTo == "" {
+		return newToastResp(ToastError, "问题已失效，请直接发送消息提问"), nil
+	}
+
+	// 重复点击或回调重试时只回答一次
+	processedKey := fmt.Sprintf("suggested:%s:%s", replyTo, question)
+	if m.msgCache.IfProcessed(processedKey) {
+		return newToastResp(ToastInfo, "该问题已提交"), nil
+	}
+	m.msgCache.TagProcessed(processedKey)
+
+	handlerType := PrivateHandler
+	if cardMsg.ChatType == GroupChatType {
+		handlerType = GroupHandler
+	}
+	userId := cardAction.UserID
+	if userId == "" {
+		userId = cardAction.OpenID
+	}
+	sessionId := cardMsg.SessionId
+	// 每个建议问题是独立的一轮对话，以去重键作为消息ID，同一卡片可追问多个问题
+	msgId := processedKey
+	info := &MsgInfo{
+		handlerType: handlerType,
+		msgType:     "text",
+		sessionId:   &sessionId,
+		msgId:       &msgId,
+		replyTo:     replyTo,
+		qParsed:     question,
+		userId:      userId,
+	}
+
+	// 飞书要求3秒内响应回调，回答在后台生成
+	goBackground(func() {
+		if err := answerMessage(context.Background(), m, info, fmt.Sprintf("💡 %s\n\n", question)); err != nil {
+			log.Printf("[Handlers] Failed to answer suggested question in session %s: %v", sessionId, err)
+		}
+	})
+	return newToastResp(ToastSuccess, "已提问: "+question), nil
+}
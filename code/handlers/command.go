@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"log"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandHandlerFunc defines the function type for handling a text command.
+// args is the text following a prefix alias, empty for exact aliases.
+type CommandHandlerFunc func(ctx context.Context, a *ActionInfo, args string) error
+
+// Command defines a text command
+type Command struct {
+	Name     string             // 命令名称
+	Aliases  []string           // 完全匹配的别名，如 "/clear"、"清除"
+	Prefixes []string           // 带参数的前缀别名，如 "/system "、"扮演 "
+	Help     string             // 帮助说明
+	Handler  CommandHandlerFunc // 命令处理函数
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   []*Command
+)
+
+// RegisterCommand adds a command to the registry
+func RegisterCommand(cmd *Command) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	commands = append(commands, cmd)
+}
+
+// GetCommands returns all registered commands in registration order
+func GetCommands() []*Command {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	result := make([]*Command, len(commands))
+	copy(result, commands)
+	return result
+}
+
+// MatchCommand finds the command matching the text and returns its arguments.
+// Exact aliases of all commands are checked before prefix aliases.
+func MatchCommand(text string) (*Command, string, bool) {
+	registered := GetCommands()
+	for _, cmd := range registered {
+		if _, ok := utils.EitherTrimEqual(text, cmd.Aliases...); ok {
+			return cmd, "", true
+		}
+	}
+	text = strings.TrimSpace(text)
+	for _, cmd := range registered {
+		if args, ok := utils.EitherCutPrefix(text, cmd.Prefixes...); ok {
+			return cmd, strings.TrimSpace(args), true
+		}
+	}
+	return nil, "", false
+}
+
+// CommandAction runs the matching command and stops the chain when one is found
+type CommandAction struct{}
+
+func (a *CommandAction) Execute(info *ActionInfo) bool {
+	cmd, args, ok := MatchCommand(info.info.qParsed)
+	if !ok {
+		return true
+	}
+
+	log.Printf("[Command] Executing command %s for session %s", cmd.Name, *info.info.sessionId)
+	ctx, cancel := context.WithTimeout(*info.ctx, 10*time.Second)
+	defer cancel()
+	if err := cmd.Handler(ctx, info, args); err != nil {
+		log.Printf("[Command] Command %s failed: %v", cmd.Name, err)
+		if replyErr := replyMarkdown(ctx, info, "🤖 机器人提醒", larkcard.TemplateRed, fmt.Sprintf("命令执行失败: %v", err)); replyErr != nil {
+			log.Printf("[Command] Failed to reply error: %v", replyErr)
+		}
+	}
+	return false
+}
+
+// replyCard replies to the message of the action with a card
+func replyCard(ctx context.Context, a *ActionInfo, card *larkcard.MessageCard) error {
+	content, err := card.String()
+	if err != nil {
+		return err
+	}
+	_, err = a.handler.cardCreator.ReplyCard(ctx, *a.info.msgId, content)
+	return err
+}
+
+// replyMarkdown replies to the message of the action with a markdown card
+func replyMarkdown(ctx context.Context, a *ActionInfo, title string, template string, content string) error {
+	return replyCard(ctx, a, cardtemplate.Markdown(title, template, content))
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"start-feishubot/initialization"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/services/core"
+	"strings"
+)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "clear",
+		Aliases: []string{"/clear", "清除", "清除话题"},
+		Help:    "清除当前会话的上下文",
+		Handler: clearCommand,
+	})
+	RegisterCommand(&Command{
+		Name:     "system",
+		Prefixes: []string{"/system ", "角色扮演 "},
+		Help:     "设置系统提示词，如 `/system 你是一名翻译`",
+		Handler:  systemCommand,
+	})
+	RegisterCommand(&Command{
+		Name:     "role",
+		Aliases:  []string{"/role", "角色列表"},
+		Prefixes: []string{"/role ", "扮演 "},
+		Help:     "选择内置角色，或直接指定角色名，如 `/role 翻译官`",
+		Handler:  roleCommand,
+	})
+	RegisterCommand(&Command{
+		Name:     "mode",
+		Aliases:  []string{"/mode", "会话模式"},
+		Prefixes: []string{"/mode ", "切换模式 "},
+		Help:     "切换当前聊天的会话模式: chat、thread、user、group_user，群内任何成员均可切换，重启后恢复默认",
+		Handler:  modeCommand,
+	})
+	RegisterCommand(&Command{
+		Name:    "usage",
+		Aliases: []string{"/usage", "用量"},
+		Help:    "查看当前会话的上下文、文档和Token用量",
+		Handler: usageCommand,
+	})
+	RegisterCommand(&Command{
+		Name:    "help",
+		Aliases: []string{"/help", "帮助"},
+		Help:    "查看帮助",
+		Handler: helpCommand,
+	})
+}
+
+func clearCommand(ctx context.Context, a *ActionInfo, args string) error {
+	a.handler.sessionCache.Clear(*a.info.sessionId)
+	return replyMarkdown(ctx, a, "🆑 机器人提醒", larkcard.TemplateGrey, "已清除当前会话的上下文，可以开始新的话题了")
+}
+
+func systemCommand(ctx context.Context, a *ActionInfo, args string) error {
+	if args == "" {
+		return fmt.Errorf("请在命令后输入系统提示词")
+	}
+	sessionId := *a.info.sessionId
+	a.handler.sessionCache.Clear(sessionId)
+	a.handler.sessionCache.SetMsg(sessionId, []ai.Message{
+		{
+			Role:    "system",
+			Content: args,
+		},
+	})
+	return replyMarkdown(ctx, a, "🥷 已进入角色扮演模式", larkcard.TemplateIndigo, args)
+}
+
+func roleCommand(ctx context.Context, a *ActionInfo, args string) error {
+	if args != "" {
+		role := initialization.GetRoleByTitle(args)
+		if role == nil {
+			return fmt.Errorf("角色 %s 不存在", args)
+		}
+		applyRole(a.handler.sessionCache, *a.info.sessionId, role)
+		return replyMarkdown(ctx, a, "🥷 已切换角色", larkcard.TemplateIndigo, fmt.Sprintf("当前角色: **%s**", role.Title))
+	}
+
+	tags := initialization.GetAllUniqueTags()
+	if tags == nil || len(*tags) == 0 {
+		return fmt.Errorf("没有可用的内置角色")
+	}
+	return replyCard(ctx, a, newRoleTagCard(NewCardMsg(RoleTagsChooseKind, a.info, nil), *tags))
+}
+
+// modeCommand shows or switches the session scope of the chat. Any member
+// of a group may switch it, and the scope is kept in memory only, so it
+// falls back to the configured scope after a restart.
+func modeCommand(ctx context.Context, a *ActionInfo, args string) error {
+	if args == "" {
+		return replyMarkdown(ctx, a, "🔀 会话模式", larkcard.TemplateBlue,
+			fmt.Sprintf("当前会话模式: **%s**\n可选: chat、thread、user、group_user", getSessionScope(a.info.chatId)))
+	}
+
+	scope := core.SessionScope(strings.ToLower(args))
+	if !isValidSessionScope(scope) {
+		return fmt.Errorf("未知的会话模式 %s，可选: chat、thread、user、group_user", args)
+	}
+	setChatSessionScope(a.info.chatId, scope)
+	return replyMarkdown(ctx, a, "🔀 会话模式", larkcard.TemplateBlue, fmt.Sprintf("已切换会话模式: **%s**\n重启后恢复为默认模式", scope))
+}
+
+func usageCommand(ctx context.Context, a *ActionInfo, args string) error {
+	sessionCache := a.handler.sessionCache
+	messages := sessionCache.GetMessages(*a.info.sessionId)
+	sessions := sessionCache.GetUserSessions(a.info.userId)
+	usage := sessionCache.GetUsage(*a.info.sessionId)
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("会话模式: **%s**\n", getSessionScope(a.info.chatId)))
+	content.WriteString(fmt.Sprintf("上下文消息: **%d / %d**\n", len(messages), MaxHistoryMessages))
+	content.WriteString(fmt.Sprintf("会话文档: **%d**\n", len(sessionCache.GetDocuments(*a.info.sessionId))))
+	content.WriteString(fmt.Sprintf("Token用量: **%d** (输入 %d，输出 %d，共 %d 次回答)\n",
+		usage.TotalTokens, usage.PromptTokens, usage.CompletionTokens, usage.Answers))
+	content.WriteString(fmt.Sprintf("你的会话数: **%d**", len(sessions)))
+	return replyMarkdown(ctx, a, "📊 用量", larkcard.TemplateTurquoise, content.String())
+}
+
+func helpCommand(ctx context.Context, a *ActionInfo, args string) error {
+	var content strings.Builder
+	for _, cmd := range GetCommands() {
+		names := append(append([]string{}, cmd.Aliases...), cmd.Prefixes...)
+		for i, name := range names {
+			names[i] = "`" + strings.TrimSpace(name) + "`"
+		}
+		content.WriteString(fmt.Sprintf("**%s** %s\n%s\n\n", cmd.Name, strings.Join(dedupe(names), " "), cmd.Help))
+	}
+	clearButton := cardtemplate.NewButton("清除话题", NewCardMsg(ClearCardKind, a.info, nil)).
+		Danger().
+		Confirm("清除话题", "确定清除当前会话的上下文吗？")
+	return replyCard(ctx, a, cardtemplate.Help(strings.TrimSpace(content.String()), clearButton))
+}
+
+// dedupe removes duplicated strings while keeping the order
+func dedupe(items []string) []string {
+	seen := make(map[string]bool, len(items))
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import "testing"
+
+func TestMatchCommand(t *testing.T) {
+	tests := []struct {
+		text     string
+		wantName string // 为空表示不是命令
+		wantArgs string
+	}{
+		{"/clear", "clear", ""},
+		{"  /clear  ", "clear", ""},
+		{"清除", "clear", ""},
+		{"/clearall", "", ""},
+		{"/clear 所有", "", ""},
+		{"/system 你是一名翻译", "system", "你是一名翻译"},
+		{"角色扮演 你是一名翻译", "system", "你是一名翻译"},
+		{"/system", "", ""},
+		{"/role", "role", ""},
+		{"/role  翻译官 ", "role", "翻译官"},
+		{"扮演 翻译官", "role", "翻译官"},
+		{"/mode", "mode", ""},
+		{"/mode thread", "mode", "thread"},
+		{"/usage", "usage", ""},
+		{"帮助", "help", ""},
+		{"清除一下缓存怎么操作", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			cmd, args, ok := MatchCommand(tt.text)
+			if tt.wantName == "" {
+				if ok {
+					t.Errorf("MatchCommand(%q) = %s, want no command", tt.text, cmd.Name)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("MatchCommand(%q) found no command, want %s", tt.text, tt.wantName)
+			}
+			if cmd.Name != tt.wantName || args != tt.wantArgs {
+				t.Errorf("MatchCommand(%q) = %s %q, want %s %q", tt.text, cmd.Name, args, tt.wantName, tt.wantArgs)
+			}
+		})
+	}
+}
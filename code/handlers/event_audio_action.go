@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"start-feishubot/services/feishu"
+	"start-feishubot/utils/audio"
+	"strings"
+	"time"
+)
+
+// MaxAudioSize is the largest voice message transcribed, matching the Dify
+// speech to text limit
+const MaxAudioSize = 15 << 20
+
+// transcribeAudio downloads the opus voice of a message, converts it to wav
+// and transcribes it with the speech to text backend
+func transcribeAudio(ctx context.Context, handler *MessageHandler, msgId string, fileKey string, userId string) (string, error) {
+	if handler.transcriber == nil {
+		return "", fmt.Errorf("speech to text is not configured")
+	}
+	if handler.resources == nil {
+		return "", fmt.Errorf("resource downloader not initialized")
+	}
+
+	downloadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	resource, err := handler.resources.DownloadResource(downloadCtx, msgId, fileKey, feishu.ResourceTypeFile, MaxAudioSize)
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("failed to download audio: %v", err)
+	}
+
+	wavPath, err := convertOggToWav(resource.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert audio: %v", err)
+	}
+	defer os.Remove(wavPath)
+
+	transcribeCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	startTime := time.Now()
+	text, err := handler.transcriber.Transcribe(transcribeCtx, userId, wavPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe audio: %v", err)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("empty transcript")
+	}
+	log.Printf("Transcribed audio of message %s in %d ms", msgId, time.Since(startTime).Milliseconds())
+	return text, nil
+}
+
+// convertOggToWav writes the ogg/opus data to a temporary wav file and returns its path
+func convertOggToWav(data []byte) (wavPath string, err error) {
+	output, err := os.CreateTemp("", "feishu-audio-*.wav")
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+
+	// 解码器遇到损坏的音频会panic
+	defer func() {
+		if r := recover(); r != nil {
+			os.Remove(output.Name())
+			wavPath, err = "", fmt.Errorf("decode audio: %v", r)
+		}
+	}()
+
+	if err := audio.OggToWav(bytes.NewReader(data), output); err != nil {
+		os.Remove(output.Name())
+		return "", err
+	}
+	return output.Name(), nil
+}
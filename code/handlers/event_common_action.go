@@ -3,6 +3,10 @@ package handlers
 import (
 	"context"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"start-feishubot/services/core"
+	"start-feishubot/utils"
+	"strings"
+	"sync"
 )
 
 type EventAction interface {
@@ -34,6 +38,43 @@ func NewCommonMessageAction(ctx *context.Context, info *MsgInfo, handler *Messag
 	}
 }
 
+// GroupMentionAction filters group messages according to the group reply mode
+type GroupMentionAction struct{}
+
+func (a *GroupMentionAction) Execute(info *ActionInfo) bool {
+	if info.info.handlerType != GroupHandler {
+		return true
+	}
+
+	switch getGroupReplyMode() {
+	case core.GroupReplyAlways:
+		return true
+	case core.GroupReplyKeyword:
+		if info.handler.judgeIfMentionMe(info.info.mention) {
+			return true
+		}
+		prefix := globalConfig.GetGroupKeywordPrefix()
+		if prefix == "" {
+			return false
+		}
+		if q, ok := utils.CutPrefix(info.info.qParsed, prefix); ok {
+			info.info.qParsed = strings.TrimSpace(q)
+			return true
+		}
+		return false
+	default:
+		return info.handler.judgeIfMentionMe(info.info.mention)
+	}
+}
+
+// getGroupReplyMode returns the configured group reply mode, mention-only by default
+func getGroupReplyMode() core.GroupReplyMode {
+	if globalConfig == nil || globalConfig.GetGroupReplyMode() == "" {
+		return core.GroupReplyMention
+	}
+	return core.GroupReplyMode(globalConfig.GetGroupReplyMode())
+}
+
 func NewActionInfo(ctx *context.Context, info *MsgInfo, handler *MessageHandler) *ActionInfo {
 	return &ActionInfo{
 		ctx:     ctx,
@@ -43,12 +84,133 @@ func NewActionInfo(ctx *context.Context, info *MsgInfo, handler *MessageHandler)
 }
 
 func NewMsgInfo(msg *larkim.P2MessageReceiveV1) *MsgInfo {
+	userId := getUserId(msg)
+	scope := getSessionScope(*msg.Event.Message.ChatId)
+	sessionId := getSessionId(msg, userId, scope)
+	if scope != core.ScopeUser {
+		trackChatSession(*msg.Event.Message.ChatId, sessionId)
+	}
+	parentId := ""
+	if msg.Event.Message.ParentId != nil {
+		parentId = *msg.Event.Message.ParentId
+	}
+	rootId := ""
+	if msg.Event.Message.RootId != nil {
+		rootId = *msg.Event.Message.RootId
+	}
 	return &MsgInfo{
 		handlerType: judgeChatType(msg),
 		msgType:     *msg.Event.Message.MessageType,
+		sessionId:   &sessionId,
 		msgId:       msg.Event.Message.MessageId,
 		chatId:      *msg.Event.Message.ChatId,
-		userId:      *msg.Event.Sender.SenderId.UserId,
+		userId:      userId,
 		mention:     msg.Event.Message.Mentions,
+		parentId:    parentId,
+		rootId:      rootId,
+	}
+}
+
+// getUserId returns the sender's user_id, falling back to open_id when the
+// app has no permission to read user IDs
+func getUserId(msg *larkim.P2MessageReceiveV1) string {
+	senderId := msg.Event.Sender.SenderId
+	if senderId.UserId != nil && *senderId.UserId != "" {
+		return *senderId.UserId
+	}
+	if senderId.OpenId != nil {
+		return *senderId.OpenId
+	}
+	return ""
+}
+
+// chatSessionScopes holds the session scopes switched by the mode command,
+// keyed by chat ID. They are not persisted and reset on restart.
+var chatSessionScopes sync.Map
+
+// setChatSessionScope overrides the session scope of a chat
+func setChatSessionScope(chatId string, scope core.SessionScope) {
+	chatSessionScopes.Store(chatId, scope)
+}
+
+// chatSessions indexes the sessions derived from each chat, keyed by chat ID,
+// so that all of them can be cleared when the chat is disbanded
+var chatSessions = struct {
+	sync.Mutex
+	ids map[string]map[string]struct{}
+}{ids: make(map[string]map[string]struct{})}
+
+// trackChatSession records that the session belongs to the chat
+func trackChatSession(chatId, sessionId string) {
+	chatSessions.Lock()
+	defer chatSessions.Unlock()
+	ids, ok := chatSessions.ids[chatId]
+	if !ok {
+		ids = make(map[string]struct{})
+		chatSessions.ids[chatId] = ids
+	}
+	ids[sessionId] = struct{}{}
+}
+
+// forgetChat drops the session scope and session index of the chat and
+// returns the sessions derived from it, including the chat session itself
+func forgetChat(chatId string) []string {
+	chatSessionScopes.Delete(chatId)
+
+	chatSessions.Lock()
+	ids := chatSessions.ids[chatId]
+	delete(chatSessions.ids, chatId)
+	chatSessions.Unlock()
+
+	sessionIds := []string{chatId}
+	for id := range ids {
+		if id != chatId {
+			sessionIds = append(sessionIds, id)
+		}
+	}
+	return sessionIds
+}
+
+// isValidSessionScope reports whether the scope is a known session scope
+func isValidSessionScope(scope core.SessionScope) bool {
+	switch scope {
+	case core.ScopeChat, core.ScopeThread, core.ScopeUser, core.ScopeGroupUser:
+		return true
+	}
+	return false
+}
+
+// getSessionScope returns the session scope of a chat, falling back to the
+// configured scope and then to thread
+func getSessionScope(chatId string) core.SessionScope {
+	if scope, ok := chatSessionScopes.Load(chatId); ok {
+		return scope.(core.SessionScope)
+	}
+	if globalConfig == nil || globalConfig.GetSessionScope() == "" {
+		return core.ScopeThread
+	}
+	return core.SessionScope(globalConfig.GetSessionScope())
+}
+
+// getSessionId derives the session ID of a message according to the scope
+func getSessionId(msg *larkim.P2MessageReceiveV1, userId string, scope core.SessionScope) string {
+	chatId := *msg.Event.Message.ChatId
+	isGroup := judgeChatType(msg) == GroupHandler
+
+	switch scope {
+	case core.ScopeChat:
+		return chatId
+	case core.ScopeUser:
+		return userId
+	case core.ScopeGroupUser:
+		if isGroup {
+			return chatId + ":" + userId
+		}
+		return chatId
+	default:
+		if rootId := msg.Event.Message.RootId; rootId != nil && *rootId != "" {
+			return *rootId
+		}
+		return chatId
 	}
 }
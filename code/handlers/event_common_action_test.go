@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"start-feishubot/services"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/config"
+	"testing"
+)
+
+// newTestMessageEvent builds a group text message event, rootId is empty
+// for messages outside of threads
+func newTestMessageEvent(t *testing.T, messageId string, rootId string) *larkim.P2MessageReceiveV1 {
+	t.Helper()
+	body := `{"event":{"sender":{"sender_id":{"open_id":"ou_thread_user"}},"message":{` +
+		`"message_id":"` + messageId + `","root_id":"` + rootId + `","parent_id":"` + rootId + `",` +
+		`"chat_id":"oc_thread_chat","chat_type":"group","message_type":"text","content":"{\"text\":\"hi\"}"}}}`
+	var event larkim.P2MessageReceiveV1
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		t.Fatal(err)
+	}
+	return &event
+}
+
+func TestThreadReplyKeepsRootTurn(t *testing.T) {
+	oldConfig := globalConfig
+	globalConfig = &config.ConfigImpl{SessionScope: "thread"}
+	defer func() { globalConfig = oldConfig }()
+
+	sessionCache := services.GetSessionCache()
+
+	// The root message is answered in the chat session
+	root := NewMsgInfo(newTestMessageEvent(t, "om_thread_root", ""))
+	if *root.sessionId != "oc_thread_chat" {
+		t.Fatalf("root session = %s, want the chat session", *root.sessionId)
+	}
+	defer sessionCache.Clear(*root.sessionId)
+	saveConversation(sessionCache, *root.sessionId, root, getSessionHistory(sessionCache, root),
+		ai.Message{Role: "user", Content: "root question"}, "root answer", "om_thread_card", "")
+
+	// The first reply in the thread starts the thread session with the root turn
+	reply := NewMsgInfo(newTestMessageEvent(t, "om_thread_reply", "om_thread_root"))
+	if *reply.sessionId != "om_thread_root" {
+		t.Fatalf("reply session = %s, want the thread session", *reply.sessionId)
+	}
+	defer sessionCache.Clear(*reply.sessionId)
+	history := getSessionHistory(sessionCache, reply)
+	if len(history) != 2 || history[0].Content != "root question" || history[1].Content != "root answer" {
+		t.Fatalf("reply history = %+v, want the root turn", history)
+	}
+
+	// Later replies use the thread session
+	saveConversation(sessionCache, *reply.sessionId, reply, history,
+		ai.Message{Role: "user", Content: "follow-up"}, "follow-up answer", "om_thread_card2", "")
+	next := NewMsgInfo(newTestMessageEvent(t, "om_thread_next", "om_thread_root"))
+	if history := getSessionHistory(sessionCache, next); len(history) != 4 {
+		t.Errorf("next history has %d messages, want 4", len(history))
+	}
+}
+
+func TestGroupMentionAction(t *testing.T) {
+	bot := newTestMention("@_user_1", testBotOpenId, "Bot")
+	other := newTestMention("@_user_2", "ou_other", "张三")
+	tests := []struct {
+		name      string
+		mode      string
+		botOpenId string
+		mentions  []*larkim.MentionEvent
+		text      string
+		want      bool
+		wantText  string
+	}{
+		{"mention bot", "mention", testBotOpenId, []*larkim.MentionEvent{bot}, "你好", true, "你好"},
+		{"mention other member", "mention", testBotOpenId, []*larkim.MentionEvent{other}, "你好", false, "你好"},
+		{"no mention", "", testBotOpenId, nil, "你好", false, "你好"},
+		{"unknown bot", "mention", "", []*larkim.MentionEvent{other}, "你好", false, "你好"},
+		{"always", "always", testBotOpenId, nil, "你好", true, "你好"},
+		{"keyword", "keyword", testBotOpenId, nil, "AI 你好", true, "你好"},
+		{"keyword mention", "keyword", testBotOpenId, []*larkim.MentionEvent{bot}, "你好", true, "你好"},
+		{"keyword missing", "keyword", testBotOpenId, []*larkim.MentionEvent{other}, "你好", false, "你好"},
+	}
+
+	oldConfig := globalConfig
+	defer func() { globalConfig = oldConfig }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globalConfig = &config.ConfigImpl{GroupReplyMode: tt.mode, GroupKeywordPrefix: "AI"}
+			info := &ActionInfo{
+				info:    &MsgInfo{handlerType: GroupHandler, mention: tt.mentions, qParsed: tt.text},
+				handler: &MessageHandler{botOpenId: tt.botOpenId},
+			}
+			if got := (&GroupMentionAction{}).Execute(info); got != tt.want {
+				t.Errorf("Execute() = %v, want %v", got, tt.want)
+			}
+			if info.info.qParsed != tt.wantText {
+				t.Errorf("qParsed = %q, want %q", info.info.qParsed, tt.wantText)
+			}
+		})
+	}
+}
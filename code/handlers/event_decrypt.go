@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"start-feishubot/services/config"
+)
+
+// EncryptedEvent represents an encrypted callback body
+type EncryptedEvent struct {
+	Encrypt string `json:"encrypt"`
+}
+
+// RequestVerifier verifies the signature of a raw request body
+type RequestVerifier func(r *http.Request, body []byte, cfg config.Config) error
+
+// DecryptEvent decrypts an AES-256-CBC encrypted payload with the encrypt key.
+// The key is the SHA-256 of the encrypt key and the IV is the first block of the cipher text.
+func DecryptEvent(encrypt string, encryptKey string) ([]byte, error) {
+	buf, err := base64.StdEncoding.DecodeString(encrypt)
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode error: %v", err)
+	}
+	if len(buf) < aes.BlockSize {
+		return nil, fmt.Errorf("cipher too short")
+	}
+
+	key := sha256.Sum256([]byte(encryptKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("AES new cipher error: %v", err)
+	}
+
+	iv := buf[:aes.BlockSize]
+	buf = buf[aes.BlockSize:]
+	if len(buf) == 0 || len(buf)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext is not a multiple of the block size")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(buf, buf)
+
+	// 去除PKCS7填充
+	padding := int(buf[len(buf)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(buf) {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return buf[:len(buf)-padding], nil
+}
+
+// VerifyMiddleware verifies the request signature and replaces an encrypted
+// body with its plain text so that later handlers can read it as usual
+func VerifyMiddleware(cfg config.Config, verify RequestVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := c.GetRawData()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		plain, err := decryptBody(body, cfg)
+		if err != nil {
+			log.Printf("[Middleware] Failed to decrypt request: %v", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// URL验证请求不带签名
+		var envelope EventEnvelope
+		if err := json.Unmarshal(plain, &envelope); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if envelope.Type != "url_verification" {
+			if err := verify(c.Request, body, cfg); err != nil {
+				log.Printf("[Middleware] Failed to verify request: %v", err)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(plain))
+		c.Next()
+	}
+}
+
+// decryptBody returns the plain text body, decrypting it when it is encrypted
+func decryptBody(body []byte, cfg config.Config) ([]byte, error) {
+	var encrypted EncryptedEvent
+	if err := json.Unmarshal(body, &encrypted); err != nil {
+		return nil, err
+	}
+	if encrypted.Encrypt == "" {
+		return body, nil
+	}
+
+	encryptKey := cfg.GetFeishuAppEncryptKey()
+	if encryptKey == "" {
+		return nil, fmt.Errorf("received encrypted request but encrypt key is not configured")
+	}
+	return DecryptEvent(encrypted.Encrypt, encryptKey)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDecryptEvent(t *testing.T) {
+	type args struct {
+		encrypt    string
+		encryptKey string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Feishu document sample",
+			args: args{
+				encrypt:    "P37w+VZImNgPEO1RBhJ6RtKl7n6zymIbEG1pReEzghk=",
+				encryptKey: "test key",
+			},
+			want: "hello world",
+		},
+		{
+			name: "Invalid base64",
+			args: args{
+				encrypt:    "not base64!",
+				encryptKey: "test key",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Cipher too short",
+			args: args{
+				encrypt:    "aGVsbG8=",
+				encryptKey: "test key",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptEvent(tt.args.encrypt, tt.args.encryptKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecryptEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("DecryptEvent() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		timestamp string
+		wantErr   bool
+	}{
+		{name: "Current", timestamp: strconv.FormatInt(now.Unix(), 10)},
+		{name: "Expired", timestamp: strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10), wantErr: true},
+		{name: "Future", timestamp: strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10), wantErr: true},
+		{name: "Missing", timestamp: "", wantErr: true},
+		{name: "Malformed", timestamp: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyTimestamp(tt.timestamp); (err != nil) != tt.wantErr {
+				t.Errorf("verifyTimestamp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
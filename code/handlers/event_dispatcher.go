@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"log"
+)
+
+// Event types handled by the dispatcher
+const (
+	MessageReceiveEventType = "im.message.receive_v1"
+	CardActionEventType     = "card.action.trigger"
+	BotAddedEventType       = "im.chat.member.bot.added_v1"
+	ChatDisbandedEventType  = "im.chat.disbanded_v1"
+)
+
+// EventEnvelope represents the outer structure of a Feishu callback
+type EventEnvelope struct {
+	Schema    string                 `json:"schema"`
+	Type      string                 `json:"type"`
+	Token     string                 `json:"token"`
+	Challenge string                 `json:"challenge"`
+	Header    *larkevent.EventHeader `json:"header"`
+}
+
+// EventHandlerFunc defines the function type for handling a v2 event body
+type EventHandlerFunc func(ctx context.Context, m *MessageHandler, body []byte) (interface{}, error)
+
+// EventHandlerMap maps event types to handlers
+type EventHandlerMap map[string]EventHandlerFunc
+
+var eventHandlerMap = EventHandlerMap{
+	MessageReceiveEventType: func(ctx context.Context, m *MessageHandler, body []byte) (interface{}, error) {
+		var event larkim.P2MessageReceiveV1
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		if event.Event == nil || event.Event.Message == nil {
+			return nil, fmt.Errorf("empty message event")
+		}
+		// 飞书要求3秒内响应，消息处理在后台完成
+		goBackground(func() {
+			if err := m.msgReceivedHandler(context.Background(), &event); err != nil {
+				log.Printf("[Dispatcher] Failed to handle message %s: %v", *event.Event.Message.MessageId, err)
+			}
+		})
+		return nil, nil
+	},
+	CardActionEventType: func(ctx context.Context, m *MessageHandler, body []byte) (interface{}, error) {
+		var event cardActionTriggerEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		return m.cardHandler(ctx, event.toCardAction())
+	},
+	BotAddedEventType: func(ctx context.Context, m *MessageHandler, body []byte) (interface{}, error) {
+		var event larkim.P2ChatMemberBotAddedV1
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		return nil, m.botAddedHandler(ctx, &event)
+	},
+	ChatDisbandedEventType: func(ctx context.Context, m *MessageHandler, body []byte) (interface{}, error) {
+		var event larkim.P2ChatDisbandedV1
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		return nil, m.chatDisbandedHandler(ctx, &event)
+	},
+}
+
+// GetEventHandler returns the handler registered for the event type
+func GetEventHandler(eventType string) EventHandlerFunc {
+	if handler, ok := eventHandlerMap[eventType]; ok {
+		return handler
+	}
+	return nil
+}
+
+// DispatchEvent routes a v2 event body to its typed handler.
+// Unknown event types are logged and acknowledged.
+func DispatchEvent(ctx context.Context, m *MessageHandler, envelope *EventEnvelope, body []byte) (interface{}, error) {
+	if envelope.Header == nil {
+		return nil, fmt.Errorf("missing event header")
+	}
+	if token := globalConfig.GetFeishuAppVerificationToken(); token != "" && envelope.Header.Token != token {
+		return nil, fmt.Errorf("invalid event token")
+	}
+
+	eventType := envelope.Header.EventType
+	handler := GetEventHandler(eventType)
+	if handler == nil {
+		log.Printf("[Dispatcher] Ignoring unsupported event type: %s, event_id: %s", eventType, envelope.Header.EventID)
+		return nil, nil
+	}
+
+	log.Printf("[Dispatcher] Dispatching event type: %s, event_id: %s", eventType, envelope.Header.EventID)
+	return handler(ctx, m, body)
+}
+
+// cardActionTriggerEvent represents the v2 card.action.trigger event
+type cardActionTriggerEvent struct {
+	Event struct {
+		Operator struct {
+			OpenID string `json:"open_id"`
+			UserID string `json:"user_id"`
+		} `json:"operator"`
+		Token  string `json:"token"`
+		Action struct {
+			Value  map[string]interface{} `json:"value"`
+			Tag    string                 `json:"tag"`
+			Option string                 `json:"option"`
+		} `json:"action"`
+		Context struct {
+			OpenMessageID string `json:"open_message_id"`
+			OpenChatID    string `json:"open_chat_id"`
+		} `json:"context"`
+	} `json:"event"`
+}
+
+// toCardAction converts the v2 event into the larkcard.CardAction used by card handlers
+func (e *cardActionTriggerEvent) toCardAction() *larkcard.CardAction {
+	cardAction := &larkcard.CardAction{
+		OpenID:        e.Event.Operator.OpenID,
+		UserID:        e.Event.Operator.UserID,
+		OpenMessageID: e.Event.Context.OpenMessageID,
+		Token:         e.Event.Token,
+	}
+	cardAction.Action = &struct {
+		Value    map[string]interface{} `json:"value"`
+		Tag      string                 `json:"tag"`
+		Option   string                 `json:"option"`
+		Timezone string                 `json:"timezone"`
+	}{
+		Value:  e.Event.Action.Value,
+		Tag:    e.Event.Action.Tag,
+		Option: e.Event.Action.Option,
+	}
+	return cardAction
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	"net/http"
+	"net/http/httptest"
+	"start-feishubot/services/config"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testEventType      = "test.event_v1"
+	testOtherEventType = "test.other_event_v1"
+)
+
+// registerTestEventHandlers registers handlers for the test event types that
+// record the event type and body they receive
+func registerTestEventHandlers(t *testing.T) (gotType *string, gotBody *[]byte) {
+	t.Helper()
+	gotType, gotBody = new(string), new([]byte)
+	for _, eventType := range []string{testEventType, testOtherEventType} {
+		eventType := eventType
+		eventHandlerMap[eventType] = func(ctx context.Context, m *MessageHandler, body []byte) (interface{}, error) {
+			*gotType, *gotBody = eventType, body
+			return nil, nil
+		}
+	}
+	t.Cleanup(func() {
+		delete(eventHandlerMap, testEventType)
+		delete(eventHandlerMap, testOtherEventType)
+	})
+	return gotType, gotBody
+}
+
+// setTestConfig replaces the global config and message handler until the test ends
+func setTestConfig(t *testing.T, cfg config.Config) {
+	t.Helper()
+	oldConfig, oldHandler := globalConfig, messageHandler
+	globalConfig, messageHandler = cfg, &MessageHandler{}
+	t.Cleanup(func() { globalConfig, messageHandler = oldConfig, oldHandler })
+}
+
+// testEventBody returns a schema 2.0 event body
+func testEventBody(eventType, token string) string {
+	return fmt.Sprintf(`{"schema":"2.0","header":{"event_id":"ev_1","token":%q,"event_type":%q},"event":{"chat_id":"oc_1"}}`,
+		token, eventType)
+}
+
+// encryptTestEvent encrypts body the way Feishu encrypts event callbacks
+func encryptTestEvent(t *testing.T, body, encryptKey string) string {
+	t.Helper()
+	key := sha256.Sum256([]byte(encryptKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatalf("aes.NewCipher() error = %v", err)
+	}
+
+	padding := aes.BlockSize - len(body)%aes.BlockSize
+	plain := append([]byte(body), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	buf := make([]byte, aes.BlockSize+len(plain))
+	if _, err := rand.Read(buf[:aes.BlockSize]); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+	cipher.NewCBCEncrypter(block, buf[:aes.BlockSize]).CryptBlocks(buf[aes.BlockSize:], plain)
+
+	data, err := json.Marshal(EncryptedEvent{Encrypt: base64.StdEncoding.EncodeToString(buf)})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	return string(data)
+}
+
+// newSignedEventRequest returns an event request signed with the encrypt key
+func newSignedEventRequest(body, encryptKey string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := sha256.Sum256([]byte(timestamp + "nonce" + encryptKey + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook/event", strings.NewReader(body))
+	req.Header.Set("X-Lark-Request-Timestamp", timestamp)
+	req.Header.Set("X-Lark-Request-Nonce", "nonce")
+	req.Header.Set("X-Lark-Signature", fmt.Sprintf("%x", signature))
+	return req
+}
+
+// newTestEventRouter registers the event webhook the way main does
+func newTestEventRouter(cfg config.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/event", VerifyMiddleware(cfg, VerifyRequest), func(c *gin.Context) {
+		if err := Handler(c); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	})
+	return r
+}
+
+func TestEventHandlersRegistered(t *testing.T) {
+	for _, eventType := range []string{MessageReceiveEventType, CardActionEventType, BotAddedEventType, ChatDisbandedEventType} {
+		if GetEventHandler(eventType) == nil {
+			t.Errorf("GetEventHandler(%s) = nil, want a handler", eventType)
+		}
+	}
+}
+
+func TestDispatchEvent(t *testing.T) {
+	setTestConfig(t, &config.ConfigImpl{FeishuAppVerificationToken: "test-token"})
+	gotType, _ := registerTestEventHandlers(t)
+
+	tests := []struct {
+		name     string
+		header   *larkevent.EventHeader
+		wantType string
+		wantErr  bool
+	}{
+		{
+			name:     "Routed by event type",
+			header:   &larkevent.EventHeader{EventID: "ev_1", EventType: testEventType, Token: "test-token"},
+			wantType: testEventType,
+		},
+		{
+			name:     "Other event type",
+			header:   &larkevent.EventHeader{EventID: "ev_2", EventType: testOtherEventType, Token: "test-token"},
+			wantType: testOtherEventType,
+		},
+		{
+			name:   "Unknown event type is acknowledged",
+			header: &larkevent.EventHeader{EventID: "ev_3", EventType: "test.unknown_v1", Token: "test-token"},
+		},
+		{
+			name:    "Token mismatch",
+			header:  &larkevent.EventHeader{EventID: "ev_4", EventType: testEventType, Token: "wrong-token"},
+			wantErr: true,
+		},
+		{
+			name:    "Missing header",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*gotType = ""
+			result, err := DispatchEvent(context.Background(), messageHandler, &EventEnvelope{Schema: "2.0", Header: tt.header}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DispatchEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result != nil {
+				t.Errorf("DispatchEvent() result = %v, want nil", result)
+			}
+			if *gotType != tt.wantType {
+				t.Errorf("dispatched to %q, want %q", *gotType, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestEventWebhook(t *testing.T) {
+	cfg := &config.ConfigImpl{
+		FeishuAppVerificationToken: "test-token",
+		FeishuAppEncryptKey:        "test-key",
+	}
+	setTestConfig(t, cfg)
+	gotType, gotBody := registerTestEventHandlers(t)
+	r := newTestEventRouter(cfg)
+
+	plainBody := testEventBody(testEventType, "test-token")
+	encryptedBody := encryptTestEvent(t, plainBody, cfg.FeishuAppEncryptKey)
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantStatus int
+		wantType   string
+	}{
+		{
+			name:       "Routed event",
+			req:        newSignedEventRequest(plainBody, cfg.FeishuAppEncryptKey),
+			wantStatus: http.StatusOK,
+			wantType:   testEventType,
+		},
+		{
+			name:       "Unknown event type",
+			req:        newSignedEventRequest(testEventBody("test.unknown_v1", "test-token"), cfg.FeishuAppEncryptKey),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Token mismatch",
+			req:        newSignedEventRequest(testEventBody(testEventType, "wrong-token"), cfg.FeishuAppEncryptKey),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "Encrypted envelope",
+			req:        newSignedEventRequest(encryptedBody, cfg.FeishuAppEncryptKey),
+			wantStatus: http.StatusOK,
+			wantType:   testEventType,
+		},
+		{
+			name:       "Encrypted envelope with bad signature",
+			req:        newSignedEventRequest(encryptedBody, "wrong-key"),
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*gotType, *gotBody = "", nil
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if *gotType != tt.wantType {
+				t.Errorf("dispatched to %q, want %q", *gotType, tt.wantType)
+			}
+			if tt.wantType != "" && string(*gotBody) != plainBody {
+				t.Errorf("handler body = %s, want %s", *gotBody, plainBody)
+			}
+			if tt.wantStatus == http.StatusOK && tt.wantType == "" && !strings.Contains(w.Body.String(), "success") {
+				t.Errorf("body = %s, want the event acknowledged", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"log"
+	"path/filepath"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/core"
+	"start-feishubot/services/feishu"
+	"strings"
+	"time"
+)
+
+// MaxDocumentSize is the largest document accepted, matching the Dify upload limit
+const MaxDocumentSize = 15 << 20
+
+// AllowedDocumentTypes maps the accepted document extensions to their MIME types
+var AllowedDocumentTypes = map[string]string{
+	".pdf":      "application/pdf",
+	".docx":     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".txt":      "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+}
+
+// handleDocument downloads a document attachment and uploads it to the AI
+// provider, so that later questions of the session can refer to it
+func handleDocument(ctx context.Context, a *ActionInfo, fileKey string, fileName string) error {
+	info := a.info
+	handler := a.handler
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	mimeType, ok := AllowedDocumentTypes[ext]
+	if !ok {
+		return replyDocumentError(ctx, a, fmt.Sprintf("不支持的文件类型 **%s**，目前支持 PDF、DOCX、TXT 和 Markdown", fileName))
+	}
+
+	uploader, ok := handler.dify.(ai.FileUploader)
+	if !ok {
+		return replyDocumentError(ctx, a, "当前AI服务不支持文档上传")
+	}
+	if handler.resources == nil {
+		return fmt.Errorf("resource downloader not initialized")
+	}
+
+	downloadCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	resource, err := handler.resources.DownloadResource(downloadCtx, *info.msgId, fileKey, feishu.ResourceTypeFile, MaxDocumentSize)
+	cancel()
+	if errors.Is(err, core.ErrResourceTooLarge) {
+		return replyDocumentError(ctx, a, fmt.Sprintf("文件 **%s** 超过 %d MB 的大小限制", fileName, MaxDocumentSize>>20))
+	}
+	if err != nil {
+		log.Printf("Failed to download file %s of message %s: %v", fileName, *info.msgId, err)
+		return replyDocumentError(ctx, a, fmt.Sprintf("文件 **%s** 下载失败，请重试", fileName))
+	}
+
+	sessionId := *info.sessionId
+	doc := ai.File{
+		Type:     ai.FileTypeDocument,
+		Name:     fileName,
+		MimeType: mimeType,
+		Data:     resource.Data,
+	}
+	uploadCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	// Dify文件归属于user，与对话使用相同的会话ID
+	doc.UploadID, err = uploader.UploadFile(uploadCtx, sessionId, doc)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to upload file %s of message %s: %v", fileName, *info.msgId, err)
+		return replyDocumentError(ctx, a, fmt.Sprintf("文件 **%s** 上传失败，请重试", fileName))
+	}
+
+	if err := handler.sessionCache.AddDocument(sessionId, info.userId, doc); err != nil {
+		log.Printf("Session %s: failed to add document %s: %v", sessionId, fileName, err)
+		return replyDocumentError(ctx, a, fmt.Sprintf("文件 **%s** 保存失败，请稍后重试", fileName))
+	}
+	log.Printf("Session %s: added document %s (%s)", sessionId, fileName, doc.UploadID)
+	return replyMarkdown(ctx, a, "📄 已接收文档", larkcard.TemplateGreen,
+		fmt.Sprintf("**%s**\n接下来的提问将参考该文档，发送 `/clear` 可清除", fileName))
+}
+
+// replyDocumentError replies a card explaining why a document was rejected
+func replyDocumentError(ctx context.Context, a *ActionInfo, content string) error {
+	return replyMarkdown(ctx, a, "📄 无法处理文档", larkcard.TemplateRed, content)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"start-feishubot/services/ai"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultTranscriptChunkSize is the number of characters per transcript chunk
+// used when transcript_chunk_size is not configured
+const DefaultTranscriptChunkSize = 6000
+
+// DefaultForwardPrompt is the instruction for merged forwards sent without text
+const DefaultForwardPrompt = "请总结以上聊天记录"
+
+// expandMergeForward builds a speaker attributed transcript of a merge_forward message
+func expandMergeForward(ctx context.Context, handler *MessageHandler, msgId string) (string, error) {
+	if handler.msgReader == nil {
+		return "", fmt.Errorf("message reader not initialized")
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	messages, err := handler.msgReader.GetMergeForward(fetchCtx, msgId)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "", fmt.Errorf("empty merge_forward message %s", msgId)
+	}
+
+	speakers := make(map[string]string)
+	var lines []string
+	for _, msg := range messages {
+		text := quotedPlaceholders[msg.MsgType]
+		if msg.MsgType == "merge_forward" {
+			text = "[聊天记录]"
+		} else if content, err := parseMessageContent(msg.MsgType, msg.Content); err == nil && content.Text != "" {
+			text = content.Text
+		}
+		if text == "" {
+			continue
+		}
+
+		speaker := getSpeakerName(ctx, handler, speakers, msg.SenderID, msg.SenderType)
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", formatMessageTime(msg.CreateTime), speaker, text))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// getSpeakerName resolves the name of a sender, numbering users whose name can't be read
+func getSpeakerName(ctx context.Context, handler *MessageHandler, speakers map[string]string, senderId string, senderType string) string {
+	if name, ok := speakers[senderId]; ok {
+		return name
+	}
+
+	name := ""
+	if senderType == "app" {
+		name = "机器人"
+	} else {
+		nameCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		userName, err := handler.msgReader.GetUserName(nameCtx, senderId)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to get name of user %s: %v", senderId, err)
+		}
+		name = userName
+	}
+	if name == "" {
+		name = fmt.Sprintf("用户%d", len(speakers)+1)
+	}
+	speakers[senderId] = name
+	return name
+}
+
+// formatMessageTime formats a millisecond timestamp of the IM API
+func formatMessageTime(createTime string) string {
+	ms, err := strconv.ParseInt(createTime, 10, 64)
+	if err != nil {
+		return "--:--"
+	}
+	return time.UnixMilli(ms).Format("01-02 15:04")
+}
+
+// getTranscriptChunkSize returns the configured chunk size
+func getTranscriptChunkSize() int {
+	if globalConfig == nil || globalConfig.GetTranscriptChunkSize() <= 0 {
+		return DefaultTranscriptChunkSize
+	}
+	return globalConfig.GetTranscriptChunkSize()
+}
+
+// chunkTranscript splits a transcript by lines into chunks of at most size characters
+func chunkTranscript(transcript string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	currentLen := 0
+	flush := func() {
+		if currentLen > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentLen = 0
+		}
+	}
+
+	for _, line := range strings.Split(transcript, "\n") {
+		// 超长的单行按字数硬切分
+		for utf8.RuneCountInString(line) > size {
+			flush()
+			runes := []rune(line)
+			chunks = append(chunks, string(runes[:size]))
+			line = string(runes[size:])
+		}
+
+		lineLen := utf8.RuneCountInString(line)
+		if currentLen > 0 && currentLen+1+lineLen > size {
+			flush()
+		}
+		if currentLen > 0 {
+			current.WriteString("\n")
+			currentLen++
+		}
+		current.WriteString(line)
+		currentLen += lineLen
+	}
+	flush()
+	return chunks
+}
+
+// buildForwardPrompt expands a merged forward into the prompt sent with the
+// instruction. Transcripts longer than one chunk are summarized chunk by chunk
+// first and the partial summaries are sent instead.
+func buildForwardPrompt(ctx context.Context, handler *MessageHandler, msgId string, instruction string, progress func(string)) (string, error) {
+	transcript, err := expandMergeForward(ctx, handler, msgId)
+	if err != nil {
+		return "", err
+	}
+
+	chunks := chunkTranscript(transcript, getTranscriptChunkSize())
+	if len(chunks) <= 1 {
+		return fmt.Sprintf("聊天记录:\n%s\n\n%s", transcript, instruction), nil
+	}
+
+	summaries := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		progress(fmt.Sprintf("📚 正在整理聊天记录 (%d/%d)...", i+1, len(chunks)))
+		prompt := fmt.Sprintf("以下是一段聊天记录的第%d/%d部分，请按发言人提取要点，保留关键信息、结论和待办事项:\n%s", i+1, len(chunks), chunk)
+		summary, err := completeChat(ctx, handler, prompt)
+		if err != nil {
+			return "", fmt.Errorf("failed to summarize chunk %d/%d: %v", i+1, len(chunks), err)
+		}
+		summaries = append(summaries, fmt.Sprintf("第%d部分要点:\n%s", i+1, summary))
+	}
+	return fmt.Sprintf("聊天记录较长，已分%d段整理要点:\n%s\n\n%s", len(chunks), strings.Join(summaries, "\n\n"), instruction), nil
+}
+
+// completeChat sends a standalone prompt outside of any session and returns the whole answer
+func completeChat(ctx context.Context, handler *MessageHandler, prompt string) (string, error) {
+	chatCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	events := make(chan ai.StreamEvent)
+	streamDone := make(chan error, 1)
+	go func() {
+		streamDone <- handler.dify.StreamChat(chatCtx, []ai.Message{{Role: "user", Content: prompt}}, events)
+	}()
+
+	var answer strings.Builder
+	for {
+		select {
+		case event := <-events:
+			switch e := event.(type) {
+			case ai.DeltaEvent:
+				answer.WriteString(e.Text)
+			case ai.ReplaceEvent:
+				answer.Reset()
+				answer.WriteString(e.Text)
+			}
+		case err := <-streamDone:
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(answer.String()), nil
+		}
+	}
+}
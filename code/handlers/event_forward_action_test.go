@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkTranscript(t *testing.T) {
+	tests := []struct {
+		name       string
+		transcript string
+		size       int
+		want       []string
+	}{
+		{
+			name:       "Fits in one chunk",
+			transcript: "a: 你好\nb: 在的",
+			size:       20,
+			want:       []string{"a: 你好\nb: 在的"},
+		},
+		{
+			name:       "Split by lines",
+			transcript: "a: 1234\nb: 5678\nc: 90",
+			size:       13,
+			want:       []string{"a: 1234", "b: 5678\nc: 90"},
+		},
+		{
+			name:       "Long line hard split",
+			transcript: "一二三四五六七\n八",
+			size:       3,
+			want:       []string{"一二三", "四五六", "七\n八"},
+		},
+		{
+			name:       "Empty",
+			transcript: "",
+			size:       10,
+			want:       nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkTranscript(tt.transcript, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkTranscript() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,14 +2,37 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"time"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"log"
+	"start-feishubot/services"
 	"start-feishubot/services/ai"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/services/cardupdater"
+	"start-feishubot/services/core"
+	"start-feishubot/services/feishu"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// MaxHistoryMessages is the number of history messages kept per session
+const MaxHistoryMessages = 20
+
+// AnswerIdleTimeout is how long the AI provider may send no events before
+// the answer is given up and the partial answer is shown
+const AnswerIdleTimeout = 30 * time.Second
+
+// TimeoutNotice is appended to an answer cut off by the idle timeout
+const TimeoutNotice = "> ⏱️ 回复超时，以上内容可能不完整"
+
+// DefaultImagePrompt is the question sent along with images that have no text
+const DefaultImagePrompt = "请描述这张图片"
+
+// MaxImageSize is the largest image accepted, matching the Dify upload limit
+const MaxImageSize = 10 << 20
+
 type MessageEventHandler struct {
 	ctx     *context.Context
 	info    *MsgInfo
@@ -38,111 +61,486 @@ func handleMessage(ctx context.Context, event *larkim.P2MessageReceiveV1, handle
 	// Create action info
 	actionInfo := NewActionInfo(&ctx, info, handler)
 
-	// Create message handler
-	msgHandler := NewMessageEventHandler(&ctx, info, handler)
+	// Parse content
+	content, err := parseMessageContent(info.msgType, *event.Event.Message.Content)
+	if err != nil {
+		log.Printf("Failed to parse message %s: %v", *info.msgId, err)
+		return nil
+	}
+	info.qParsed = stripMentions(content.Text, info.mention, handler.botOpenId)
+	info.imageKeys = content.ImageKeys
+	info.audioKey = content.AudioKey
+	if content.MergeForward {
+		info.forwardId = *info.msgId
+	}
+
+	// Execute actions, stop at the first one that rejects the message
+	actions := []Action{
+		NewMessageEventHandler(&ctx, info, handler), // 消息去重
+		&GroupMentionAction{},                       // 群聊@过滤
+		&CommandAction{},                            // 文本命令
+	}
+	for _, action := range actions {
+		if !action.Execute(actionInfo) {
+			return nil
+		}
+	}
 
-	// Execute handler
-	if !msgHandler.Execute(actionInfo) {
+	// Documents are uploaded for later questions instead of being answered
+	if content.FileKey != "" {
+		return handleDocument(ctx, actionInfo, content.FileKey, content.FileName)
+	}
+	if info.qParsed == "" && len(info.imageKeys) == 0 && info.audioKey == "" && info.forwardId == "" {
+		log.Printf("Empty message after parsing, skip: %s", *info.msgId)
 		return nil
 	}
+	return answerMessage(ctx, handler, info, "")
+}
 
-	// Get message content
-	content := *event.Event.Message.Content
+// answerMessage replies to the message with a card streaming the answer of
+// the AI provider. cardPrefix is shown above the answer, e.g. the question
+// when it was not typed by the user.
+func answerMessage(ctx context.Context, handler *MessageHandler, info *MsgInfo, cardPrefix string) error {
+	startTime := time.Now()
 
-	// Parse content
-	var msg struct {
-		Text string `json:"text"`
+	// Download images for vision input
+	files, err := downloadImages(ctx, handler.resources, *info.msgId, info.imageKeys)
+	if err != nil {
+		log.Printf("Failed to download images of message %s: %v", *info.msgId, err)
+		return err
 	}
-	if err := json.Unmarshal([]byte(content), &msg); err != nil {
+	if info.qParsed == "" && len(files) > 0 {
+		info.qParsed = DefaultImagePrompt
+	}
+
+	// Reply with the card holding the answer, showing a "processing" message
+	processing := cardtemplate.DefaultPendingText
+	if info.audioKey != "" {
+		processing = "🎤 正在识别语音..."
+	}
+	replyTo := *info.msgId
+	if info.replyTo != "" {
+		replyTo = info.replyTo
+	}
+	cardCtx, cardCancel := context.WithTimeout(ctx, 10*time.Second)
+	cardID, replyID, err := sendAnswerCard(cardCtx, handler, replyTo, cardtemplate.Pending(cardPrefix+processing))
+	cardCancel()
+	if err != nil {
+		log.Printf("Failed to send answer card: %v", err)
 		return err
 	}
 
-	// Create AI messages
-	messages := []ai.Message{
-		{
-			Role:    "user",
-			Content: msg.Text,
+	// Transcribe voice messages and show the transcript before answering
+	if info.audioKey != "" {
+		transcript, err := transcribeAudio(ctx, handler, *info.msgId, info.audioKey, *info.sessionId)
+		if err != nil {
+			log.Printf("Failed to transcribe audio of message %s: %v", *info.msgId, err)
+			updateCtx, updateCancel := context.WithTimeout(ctx, 10*time.Second)
+			handler.cardCreator.FinishCardContent(updateCtx, cardID, cardtemplate.Error("🎤 语音识别失败，请重试或发送文字"))
+			updateCancel()
+			return err
+		}
+		info.qParsed = transcript
+		cardPrefix = fmt.Sprintf("🎤 %s\n\n", transcript)
+
+		updateCtx, updateCancel := context.WithTimeout(ctx, 10*time.Second)
+		_, err = handler.cardCreator.UpdateCardContent(updateCtx, cardID, cardtemplate.Pending(cardPrefix+cardtemplate.DefaultPendingText))
+		updateCancel()
+		if err != nil {
+			log.Printf("Failed to update card with transcript: %v", err)
+			return err
+		}
+	}
+
+	// Expand merged forwards, either sent directly or quoted, into a transcript
+	sessionId := *info.sessionId
+	history := getSessionHistory(handler.sessionCache, info)
+	quoted := getQuotedContext(ctx, handler, info, history)
+	if info.forwardId != "" {
+		instruction := info.qParsed
+		if instruction == "" {
+			instruction = DefaultForwardPrompt
+		}
+		progress := func(status string) {
+			updateCtx, updateCancel := context.WithTimeout(ctx, 10*time.Second)
+			defer updateCancel()
+			if _, err := handler.cardCreator.UpdateCardContent(updateCtx, cardID, cardtemplate.Pending(cardPrefix+status)); err != nil {
+				log.Printf("Failed to update card with progress: %v", err)
+			}
+		}
+		prompt, err := buildForwardPrompt(ctx, handler, info.forwardId, instruction, progress)
+		if err != nil {
+			log.Printf("Failed to expand merged forward %s: %v", info.forwardId, err)
+			updateCtx, updateCancel := context.WithTimeout(ctx, 10*time.Second)
+			handler.cardCreator.FinishCardContent(updateCtx, cardID, cardtemplate.Error("📚 读取聊天记录失败，请确认机器人有权限查看这些消息"))
+			updateCancel()
+			return err
+		}
+		info.qParsed = prompt
+	}
+
+	// Create AI messages with session history
+	userMsg := ai.Message{
+		Role:    "user",
+		Content: withQuote(quoted, info.qParsed),
+		Metadata: map[string]string{
+			// Dify会话归属于user，共享会话时需使用会话ID作为user
+			"user_id":    sessionId,
+			"session_id": sessionId,
 		},
+		// 附带会话中已上传的文档，便于后续提问引用
+		Files: append(files, handler.sessionCache.GetDocuments(sessionId)...),
+	}
+	conversationID := ""
+	if meta, ok := handler.sessionCache.GetSessionMeta(sessionId); ok {
+		conversationID = meta.ConversationID
 	}
+	if conversationID != "" {
+		userMsg.Metadata["conversation_id"] = conversationID
+	}
+	messages := buildChatMessages(handler.sessionCache, sessionId, history, userMsg)
+	log.Printf("Session %s: sending %d messages (%d history)", sessionId, len(messages), len(history))
 
-	// Get response stream
-	responseStream := make(chan string)
-	defer close(responseStream)
+	// Get response events
+	events := make(chan ai.StreamEvent)
 
-	// Create context with timeout for AI request
-	aiCtx, aiCancel := context.WithTimeout(ctx, 30*time.Second)
+	// The AI request is cancelled once the provider stays idle for too long,
+	// long answers may take any time as long as events keep coming
+	aiCtx, aiCancel := context.WithCancel(ctx)
 	defer aiCancel()
+	idle := time.NewTimer(AnswerIdleTimeout)
+	defer idle.Stop()
 
 	// Get AI provider
 	aiProvider := handler.dify
 
-	// Get initial card from pool
-	log.Printf("Getting card from pool")
-	cardCtx, cardCancel := context.WithTimeout(ctx, 10*time.Second)
-	cardID, err := handler.cardPool.GetCard(cardCtx)
-	cardCancel()
-	if err != nil {
-		log.Printf("Failed to get card from pool: %v", err)
-		return fmt.Errorf("failed to get card from pool: %v", err)
-	}
-	log.Printf("Got card from pool: %s", cardID)
-
-	// Update card with initial "processing" message
-	updateCtx, updateCancel := context.WithTimeout(ctx, 10*time.Second)
-	_, err = handler.cardCreator.UpdateCardContent(updateCtx, cardID, "正在处理...")
-	updateCancel()
-	if err != nil {
-		log.Printf("Failed to update card with processing message: %v", err)
-		return err
-	}
-
 	// Stream chat
-	streamDone := make(chan error)
+	streamDone := make(chan error, 1)
 	go func() {
-		err := aiProvider.StreamChat(aiCtx, messages, responseStream)
+		err := aiProvider.StreamChat(aiCtx, messages, events)
 		if err != nil {
 			log.Printf("Error streaming chat: %v", err)
 		}
-		close(streamDone)
+		streamDone <- err
 	}()
 
-	// Process response
+	// Card updates are coalesced by a single scheduler per card
+	updater := newCardUpdater(handler.cardCreator, cardID)
+	defer updater.Stop()
+
+	// Process response, the card always shows the whole answer so far
+	var answer strings.Builder
+	steps := newAgentSteps(getAgentThoughtsMode())
+	var citations []cardtemplate.Citation
+	messageID := ""
 	for {
 		select {
-		case response, ok := <-responseStream:
-			if !ok {
-				log.Printf("Response stream closed")
-				return nil
+		case event := <-events:
+			if !idle.Stop() {
+				<-idle.C
 			}
-			log.Printf("Received response: %s", response)
+			idle.Reset(AnswerIdleTimeout)
 
-			// Create new context with timeout for each card update
-			updateCtx, updateCancel := context.WithTimeout(ctx, 10*time.Second)
-			
-			// Update card content
-			log.Printf("Updating card content for card ID: %s", cardID)
-			_, err := handler.cardCreator.UpdateCardContent(updateCtx, cardID, response)
-			
-			// Clean up context
-			updateCancel()
-			
-			if err != nil {
-				log.Printf("Failed to update card content: %v", err)
-				return err
+			switch e := event.(type) {
+			case ai.DeltaEvent:
+				answer.WriteString(e.Text)
+				updater.Update(cardtemplate.StreamingAnswer(steps.answer(cardPrefix, answer.String())))
+			case ai.ReplaceEvent:
+				answer.Reset()
+				answer.WriteString(e.Text)
+				updater.Update(cardtemplate.StreamingAnswer(steps.answer(cardPrefix, answer.String())))
+			case ai.ThoughtEvent, ai.ToolCallEvent:
+				if steps.apply(e) {
+					updater.Update(cardtemplate.StreamingAnswer(steps.answer(cardPrefix, answer.String())))
+				}
+			case ai.CitationEvent:
+				citations = append(citations, cardtemplate.Citation{
+					DatasetName:  e.DatasetName,
+					DocumentName: e.DocumentName,
+					Content:      e.Content,
+					Score:        e.Score,
+					URL:          e.URL,
+				})
+			case ai.UsageEvent:
+				log.Printf("Session %s: answer used %d tokens (%d prompt, %d completion)", sessionId, e.TotalTokens, e.PromptTokens, e.CompletionTokens)
+				handler.sessionCache.AddUsage(sessionId, core.TokenUsage{
+					Answers:          1,
+					PromptTokens:     e.PromptTokens,
+					CompletionTokens: e.CompletionTokens,
+					TotalTokens:      e.TotalTokens,
+				})
+			case ai.FinalEvent:
+				messageID = e.MessageID
+				if e.ConversationID != "" {
+					conversationID = e.ConversationID
+				}
 			}
-			log.Printf("Successfully updated card content")
 
 		case err := <-streamDone:
 			if err != nil {
 				log.Printf("Stream ended with error: %v", err)
+				finishCtx, finishCancel := context.WithTimeout(ctx, 10*time.Second)
+				updater.Update(errorCard(err))
+				if err := updater.Flush(finishCtx); err != nil {
+					log.Printf("Failed to finish card content: %v", err)
+				}
+				finishCancel()
 				return err
 			}
 			log.Printf("Stream ended successfully")
+
+			// Write the final answer and close streaming
+			finishCtx, finishCancel := context.WithTimeout(ctx, 10*time.Second)
+			final := steps.answer(cardPrefix, answer.String())
+			final.Citations = citations
+			elapsed := time.Since(startTime)
+			updater.Update(cardtemplate.FinalAnswer(final, elapsed))
+			if err := updater.Flush(finishCtx); err != nil {
+				log.Printf("Failed to finish card content: %v", err)
+			}
+			finishCancel()
+
+			// Persist the turn so that the next message has context
+			saveConversation(handler.sessionCache, sessionId, info, history, userMsg, answer.String(), replyID, conversationID)
+
+			// Suggestions are only available once the answer is finished
+			if final.Suggestions = suggestFollowUps(ctx, handler, info, messageID); len(final.Suggestions) > 0 {
+				updateCtx, updateCancel := context.WithTimeout(ctx, 10*time.Second)
+				if err := handler.cardCreator.FinishCardContent(updateCtx, cardID, cardtemplate.FinalAnswer(final, elapsed)); err != nil {
+					log.Printf("Failed to add suggested questions: %v", err)
+				}
+				updateCancel()
+			}
+
 			return nil
 
-		case <-aiCtx.Done():
-			log.Printf("AI context cancelled: %v", aiCtx.Err())
-			return aiCtx.Err()
+		case <-idle.C:
+			aiCancel()
+			log.Printf("Session %s: no events for %v, giving up the answer", sessionId, AnswerIdleTimeout)
+
+			// Keep what was answered so far and tell the user it is incomplete
+			finishCtx, finishCancel := context.WithTimeout(ctx, 10*time.Second)
+			content := cardtemplate.Error("⏱️ 回复超时，请稍后重试")
+			if answer.Len() > 0 {
+				partial := steps.answer(cardPrefix, answer.String()+"\n\n"+TimeoutNotice)
+				partial.Citations = citations
+				content = cardtemplate.FinalAnswer(partial, time.Since(startTime))
+			}
+			updater.Update(content)
+			if err := updater.Flush(finishCtx); err != nil {
+				log.Printf("Failed to finish card content: %v", err)
+			}
+			finishCancel()
+			return fmt.Errorf("answer timed out after %v without events", AnswerIdleTimeout)
+		}
+	}
+}
+
+// sendAnswerCard replies to the message with the card that will hold the answer.
+// It returns the ID used to update the card and the ID of the reply message.
+// Pooled CardKit entities are not bound to any chat, they are updated with
+// content and then sent by card ID; without a pool, or when the pool can't
+// provide a card, the reply creates a message card.
+func sendAnswerCard(ctx context.Context, handler *MessageHandler, msgId string, content string) (string, string, error) {
+	if handler.cardPool != nil {
+		cardID, err := handler.cardPool.GetCard(ctx)
+		if err == nil {
+			return sendPooledCard(ctx, handler, msgId, cardID, content)
+		}
+		// 卡片池不可用（如创建卡片熔断）时退回到消息卡片，没有打字机效果
+		log.Printf("Failed to get card from pool, replying a message card instead: %v", err)
+	}
+
+	replyID, err := handler.cardCreator.ReplyCard(ctx, msgId, content)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to reply card: %v", err)
+	}
+	return replyID, replyID, nil
+}
+
+// sendPooledCard sends a card taken from the pool as the reply to the message
+func sendPooledCard(ctx context.Context, handler *MessageHandler, msgId string, cardID string, content string) (string, string, error) {
+	log.Printf("Got card from pool: %s", cardID)
+
+	// 池中卡片已是默认等待状态，内容不同时才需要更新
+	if content != cardtemplate.Pending("") {
+		if _, err := handler.cardCreator.UpdateCardContent(ctx, cardID, content); err != nil {
+			return "", "", fmt.Errorf("failed to update pooled card: %v", err)
+		}
+	}
+	replyID, err := handler.cardCreator.ReplyCardEntity(ctx, msgId, cardID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to send pooled card %s: %v", cardID, err)
+	}
+	return cardID, replyID, nil
+}
+
+// DefaultErrorMessage is shown when the chat failed for an unclassified reason
+const DefaultErrorMessage = "回复生成失败，请稍后重试"
+
+// aiErrorMessages are the messages shown to users for the AI error codes.
+// Details of the errors may contain internal endpoints, so they are only logged.
+var aiErrorMessages = map[ai.ErrorCode]string{
+	ai.ErrCodeInvalidRequest:       "请求无法处理，请换个问法或清除话题后重试",
+	ai.ErrCodeUnauthorized:         "AI 服务鉴权失败，请联系管理员检查配置",
+	ai.ErrCodeConversationNotFound: "会话已失效，请清除话题后重试",
+	ai.ErrCodeRateLimited:          "请求过于频繁，请稍后重试",
+	ai.ErrCodeUnavailable:          "AI 服务暂时不可用，请稍后重试",
+	ai.ErrCodeConnection:           "连接 AI 服务失败，请稍后重试",
+	ai.ErrCodeStream:               "回复中断，请稍后重试",
+}
+
+// errorCard returns the card replacing the answer when the chat failed
+func errorCard(err error) string {
+	if errors.Is(err, ai.ErrQuotaExceeded) {
+		return cardtemplate.QuotaExceeded("")
+	}
+	return cardtemplate.Error(errorMessage(err))
+}
+
+// errorMessage returns the user-facing message of a chat error
+func errorMessage(err error) string {
+	var aiErr *ai.Error
+	if errors.As(err, &aiErr) {
+		if message, ok := aiErrorMessages[aiErr.Code]; ok {
+			return message
+		}
+	}
+	return DefaultErrorMessage
+}
+
+// newCardUpdater creates the update scheduler of a card with the configured limits
+func newCardUpdater(cardCreator core.CardCreator, cardID string) *cardupdater.Updater {
+	config := cardupdater.Config{}
+	if globalConfig != nil {
+		config.Interval = time.Duration(globalConfig.GetCardUpdateIntervalMs()) * time.Millisecond
+		config.MinDelta = globalConfig.GetCardUpdateMinDelta()
+		config.QPS = globalConfig.GetCardUpdateQPS()
+	}
+	return cardupdater.New(config,
+		func(ctx context.Context, content string) error {
+			_, err := cardCreator.UpdateCardContent(ctx, cardID, content)
+			return err
+		},
+		func(ctx context.Context, content string) error {
+			return cardCreator.FinishCardContent(ctx, cardID, content)
+		})
+}
+
+// downloadImages downloads the images of a message as AI input files
+func downloadImages(ctx context.Context, resources core.ResourceDownloader, msgId string, imageKeys []string) ([]ai.File, error) {
+	if len(imageKeys) == 0 {
+		return nil, nil
+	}
+	if resources == nil {
+		return nil, fmt.Errorf("resource downloader not initialized")
+	}
+
+	files := make([]ai.File, 0, len(imageKeys))
+	for _, imageKey := range imageKeys {
+		downloadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		resource, err := resources.DownloadResource(downloadCtx, msgId, imageKey, feishu.ResourceTypeImage, MaxImageSize)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to download image %s: %v", imageKey, err)
+		}
+		files = append(files, ai.File{
+			Type:     ai.FileTypeImage,
+			Name:     resource.Name,
+			MimeType: resource.MimeType,
+			Data:     resource.Data,
+		})
+	}
+	return files, nil
+}
+
+// buildChatMessages assembles the system prompt, prior turns and the new user message
+func buildChatMessages(sessionCache core.SessionCache, sessionId string, history []ai.Message, userMsg ai.Message) []ai.Message {
+	messages := make([]ai.Message, 0, len(history)+2)
+	if meta, ok := sessionCache.GetSessionMeta(sessionId); ok {
+		messages = append(messages, meta.SystemMsg...)
+	}
+	messages = append(messages, history...)
+	return append(messages, userMsg)
+}
+
+// getSessionHistory returns the history of the message's session. In thread
+// scope the root message is answered in the chat session, so the first reply
+// in a thread starts its session with the turn of the root.
+func getSessionHistory(sessionCache core.SessionCache, info *MsgInfo) []ai.Message {
+	sessionId := *info.sessionId
+	history := sessionCache.GetMessages(sessionId)
+	if len(history) > 0 || info.rootId == "" || sessionId != info.rootId {
+		return history
+	}
+	return findRootTurn(sessionCache.GetMessages(info.chatId), info.rootId)
+}
+
+// findRootTurn returns the question and answer of the turn the thread root
+// belongs to, the root being either the user message or the answer card
+func findRootTurn(history []ai.Message, rootId string) []ai.Message {
+	for i, msg := range history {
+		switch {
+		case msg.Role == "user" && msg.Metadata["message_id"] == rootId:
+			end := i + 1
+			if end < len(history) && history[end].Role == "assistant" {
+				end++
+			}
+			return history[i:end]
+		case msg.Role == "assistant" && msg.Metadata["card_id"] == rootId:
+			start := i
+			if start > 0 && history[start-1].Role == "user" {
+				start--
+			}
+			return history[start : i+1]
 		}
 	}
+	return nil
+}
+
+// saveConversation stores the user turn and the assembled assistant reply in the session
+func saveConversation(
+	sessionCache core.SessionCache,
+	sessionId string,
+	info *MsgInfo,
+	history []ai.Message,
+	userMsg ai.Message,
+	answer string,
+	replyID string,
+	conversationID string,
+) {
+	if strings.TrimSpace(answer) == "" {
+		log.Printf("Session %s: empty answer, skip saving", sessionId)
+		return
+	}
+
+	messages := append(history, ai.Message{
+		Role:    userMsg.Role,
+		Content: truncateContent(userMsg.Content, services.MaxMessageLength),
+		// 记录用户消息ID，话题回复可据此找回话题根消息的对话
+		Metadata: map[string]string{"message_id": *info.msgId},
+	}, ai.Message{
+		Role:    "assistant",
+		Content: truncateContent(answer, services.MaxMessageLength),
+		// 记录回复所在的卡片消息，用户引用卡片时可找回回复内容
+		Metadata: map[string]string{"card_id": replyID},
+	})
+	if len(messages) > MaxHistoryMessages {
+		messages = messages[len(messages)-MaxHistoryMessages:]
+	}
+
+	if err := sessionCache.SetMessages(sessionId, info.userId, messages, replyID, *info.msgId, conversationID, ""); err != nil {
+		log.Printf("Session %s: failed to save messages: %v", sessionId, err)
+	}
+}
+
+// truncateContent cuts s to at most max bytes without splitting a character
+func truncateContent(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
 }
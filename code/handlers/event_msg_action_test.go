@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"start-feishubot/services"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/cardpool"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/services/config"
+	"start-feishubot/services/core"
+	"start-feishubot/services/feishu"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "Rate limited",
+			err:  &ai.Error{Code: ai.ErrCodeRateLimited, Message: "too many requests", Status: 429},
+			want: aiErrorMessages[ai.ErrCodeRateLimited],
+		},
+		{
+			name: "Wrapped unavailable",
+			err:  fmt.Errorf("stream failed: %w", ai.WrapError(ai.ErrCodeUnavailable, "dial http://10.0.0.1/v1", errors.New("refused"))),
+			want: aiErrorMessages[ai.ErrCodeUnavailable],
+		},
+		{
+			name: "Unclassified",
+			err:  errors.New("secret internal detail"),
+			want: DefaultErrorMessage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorMessage(tt.err)
+			if got != tt.want {
+				t.Errorf("errorMessage() = %q, want %q", got, tt.want)
+			}
+			if strings.Contains(errorCard(tt.err), tt.err.Error()) {
+				t.Errorf("errorCard() shows the error details %q", tt.err)
+			}
+		})
+	}
+}
+
+// fakeCardCreator records the cards replied through the message API
+type fakeCardCreator struct {
+	core.CardCreator
+	replies []string
+}
+
+func (f *fakeCardCreator) ReplyCard(ctx context.Context, messageID string, content string) (string, error) {
+	f.replies = append(f.replies, content)
+	return "om_card_reply", nil
+}
+
+func (f *fakeCardCreator) UpdateCardContent(ctx context.Context, cardID string, content string) (string, error) {
+	return cardID, nil
+}
+
+func (f *fakeCardCreator) FinishCardContent(ctx context.Context, cardID string, content string) error {
+	return nil
+}
+
+func TestSendAnswerCardPoolBreakerOpen(t *testing.T) {
+	var failing int32
+	pool := cardpool.NewCardPoolWithConfig(func(ctx context.Context) (string, error) {
+		if atomic.LoadInt32(&failing) == 1 {
+			return "", errors.New("cardkit unavailable")
+		}
+		return "card_1", nil
+	}, cardpool.Config{MinSize: 1, MaxSize: 1})
+	defer pool.Stop()
+
+	// 取出预建的卡片后创建持续失败，触发熔断
+	ctx := context.Background()
+	atomic.StoreInt32(&failing, 1)
+	pool.GetCard(ctx)
+	for i := 0; i < cardpool.BreakerThreshold; i++ {
+		pool.GetCard(ctx)
+	}
+	if _, err := pool.GetCard(ctx); !errors.Is(err, cardpool.ErrCircuitOpen) {
+		t.Fatalf("GetCard() error = %v, want ErrCircuitOpen", err)
+	}
+
+	creator := &fakeCardCreator{}
+	handler := &MessageHandler{cardPool: pool, cardCreator: creator}
+	content := cardtemplate.Pending("")
+	cardID, replyID, err := sendAnswerCard(ctx, handler, "om_question", content)
+	if err != nil {
+		t.Fatalf("sendAnswerCard() error = %v", err)
+	}
+	if cardID != "om_card_reply" || replyID != "om_card_reply" {
+		t.Errorf("sendAnswerCard() = %s, %s, want the replied message card", cardID, replyID)
+	}
+	if len(creator.replies) != 1 || creator.replies[0] != content {
+		t.Errorf("replied cards = %q, want the pending card", creator.replies)
+	}
+}
+
+// fakeAIProvider answers every chat with the same text in one delta
+type fakeAIProvider struct {
+	answer         string
+	conversationID string
+	requests       [][]ai.Message
+}
+
+func (f *fakeAIProvider) StreamChat(ctx context.Context, messages []ai.Message, events chan<- ai.StreamEvent) error {
+	f.requests = append(f.requests, messages)
+	if err := ai.SendEvent(ctx, events, ai.DeltaEvent{Text: f.answer}); err != nil {
+		return err
+	}
+	return ai.SendEvent(ctx, events, ai.FinalEvent{MessageID: "msg_1", ConversationID: f.conversationID})
+}
+
+// newTestPrivateMessageEvent builds a private chat text message event
+func newTestPrivateMessageEvent(t *testing.T, messageId string, text string) *larkim.P2MessageReceiveV1 {
+	t.Helper()
+	content, _ := json.Marshal(map[string]string{"text": text})
+	body, _ := json.Marshal(map[string]interface{}{
+		"event": map[string]interface{}{
+			"sender": map[string]interface{}{"sender_id": map[string]string{"open_id": "ou_save_user"}},
+			"message": map[string]string{
+				"message_id":   messageId,
+				"chat_id":      "oc_save_chat",
+				"chat_type":    "p2p",
+				"message_type": "text",
+				"content":      string(content),
+			},
+		},
+	})
+	var event larkim.P2MessageReceiveV1
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	return &event
+}
+
+func TestHandleMessageSavesConversation(t *testing.T) {
+	oldConfig := globalConfig
+	globalConfig = &config.ConfigImpl{}
+	defer func() { globalConfig = oldConfig }()
+
+	sessionCache := services.GetSessionCache()
+	defer sessionCache.Clear("oc_save_chat")
+	provider := &fakeAIProvider{answer: "你好！", conversationID: "conv_1"}
+	handler := &MessageHandler{
+		sessionCache: sessionCache,
+		cardCreator:  &fakeCardCreator{},
+		msgCache:     core.NewMessageCache(),
+		dify:         provider,
+	}
+	ctx := context.Background()
+
+	// 重复投递的事件只回答一次
+	event := newTestPrivateMessageEvent(t, "om_save_1", "你好")
+	for i := 0; i < 2; i++ {
+		if err := handleMessage(ctx, event, handler); err != nil {
+			t.Fatalf("handleMessage() error = %v", err)
+		}
+	}
+	if len(provider.requests) != 1 {
+		t.Fatalf("provider was called %d times, want 1", len(provider.requests))
+	}
+
+	meta, ok := sessionCache.GetSessionMeta("oc_save_chat")
+	if !ok {
+		t.Fatal("session was not saved")
+	}
+	if meta.CardId != "om_card_reply" || meta.ConversationID != "conv_1" || meta.MessageId != "om_save_1" {
+		t.Errorf("session card = %s, conversation = %s, message = %s, want om_card_reply, conv_1, om_save_1",
+			meta.CardId, meta.ConversationID, meta.MessageId)
+	}
+	messages := sessionCache.GetMessages("oc_save_chat")
+	if len(messages) != 2 {
+		t.Fatalf("session has %d messages, want the user and assistant turns", len(messages))
+	}
+	if messages[0].Role != "user" || messages[0].Content != "你好" || messages[0].Metadata["message_id"] != "om_save_1" {
+		t.Errorf("user turn = %+v", messages[0])
+	}
+	if messages[1].Role != "assistant" || messages[1].Content != "你好！" || messages[1].Metadata["card_id"] != "om_card_reply" {
+		t.Errorf("assistant turn = %+v", messages[1])
+	}
+
+	// 下一条消息带上会话ID和历史
+	if err := handleMessage(ctx, newTestPrivateMessageEvent(t, "om_save_2", "再见"), handler); err != nil {
+		t.Fatalf("handleMessage() error = %v", err)
+	}
+	last := provider.requests[len(provider.requests)-1]
+	if got := last[len(last)-1].Metadata["conversation_id"]; got != "conv_1" {
+		t.Errorf("conversation_id = %q, want conv_1", got)
+	}
+	if messages := sessionCache.GetMessages("oc_save_chat"); len(messages) != 4 {
+		t.Errorf("session has %d messages after the second turn, want 4", len(messages))
+	}
+}
+
+// fakeResourceDownloader serves the resources of a message by file key
+type fakeResourceDownloader struct {
+	resources map[string]*core.MessageResource
+	requests  []string
+}
+
+func (f *fakeResourceDownloader) DownloadResource(ctx context.Context, messageID string, fileKey string, resourceType string, maxSize int64) (*core.MessageResource, error) {
+	f.requests = append(f.requests, messageID+"/"+fileKey+"/"+resourceType)
+	resource, ok := f.resources[fileKey]
+	if !ok {
+		return nil, errors.New("resource not found")
+	}
+	if int64(len(resource.Data)) > maxSize {
+		return nil, core.ErrResourceTooLarge
+	}
+	return resource, nil
+}
+
+func TestDownloadImages(t *testing.T) {
+	resources := &fakeResourceDownloader{resources: map[string]*core.MessageResource{
+		"img_v2_1": {Name: "img_v2_1.png", MimeType: "image/png", Data: []byte("png")},
+		"img_v2_2": {Name: "img_v2_2.jpg", MimeType: "image/jpeg", Data: []byte("jpg")},
+	}}
+	ctx := context.Background()
+
+	files, err := downloadImages(ctx, resources, "om_1", []string{"img_v2_1", "img_v2_2"})
+	if err != nil {
+		t.Fatalf("downloadImages() error = %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("downloadImages() returned %d files, want 2", len(files))
+	}
+	for i, want := range []ai.File{
+		{Type: ai.FileTypeImage, Name: "img_v2_1.png", MimeType: "image/png", Data: []byte("png")},
+		{Type: ai.FileTypeImage, Name: "img_v2_2.jpg", MimeType: "image/jpeg", Data: []byte("jpg")},
+	} {
+		got := files[i]
+		if got.Type != want.Type || got.Name != want.Name || got.MimeType != want.MimeType || string(got.Data) != string(want.Data) {
+			t.Errorf("file %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if want := "om_1/img_v2_1/" + feishu.ResourceTypeImage; resources.requests[0] != want {
+		t.Errorf("request = %s, want %s", resources.requests[0], want)
+	}
+
+	// 没有图片时不下载，任一图片失败时整条消息失败
+	if files, err := downloadImages(ctx, nil, "om_1", nil); err != nil || files != nil {
+		t.Errorf("downloadImages() without images = %v, %v, want nothing", files, err)
+	}
+	if _, err := downloadImages(ctx, resources, "om_1", []string{"img_v2_1", "img_missing"}); err == nil {
+		t.Error("downloadImages() with a missing image succeeded, want error")
+	}
+	resources.resources["img_v2_large"] = &core.MessageResource{Name: "img_v2_large.png", Data: make([]byte, MaxImageSize+1)}
+	if _, err := downloadImages(ctx, resources, "om_1", []string{"img_v2_large"}); err == nil || !strings.Contains(err.Error(), core.ErrResourceTooLarge.Error()) {
+		t.Errorf("downloadImages() with an over-size image error = %v, want the size rejected", err)
+	}
+}
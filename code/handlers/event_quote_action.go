@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/core"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxQuotedLength is the number of characters of a quoted message kept as context
+const MaxQuotedLength = 2000
+
+// quotedPlaceholders describes quoted messages that have no text
+var quotedPlaceholders = map[string]string{
+	"image": "[图片]",
+	"audio": "[语音]",
+	"file":  "[文件]",
+	"media": "[视频]",
+}
+
+// getQuotedContext returns the text of the message the user replied to. A
+// quoted bot card resolves to the assistant reply stored in the session.
+func getQuotedContext(ctx context.Context, handler *MessageHandler, info *MsgInfo, history []ai.Message) string {
+	if info.parentId == "" {
+		return ""
+	}
+	sessionId := *info.sessionId
+
+	// 引用的是机器人回复的卡片
+	if reply, ok := findAssistantReply(handler.sessionCache, sessionId, history, info.parentId); ok {
+		return reply
+	}
+	if handler.msgReader == nil {
+		return ""
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	parent, err := handler.msgReader.GetMessage(fetchCtx, info.parentId)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to get quoted message %s: %v", info.parentId, err)
+		return ""
+	}
+	if parent.MsgType == "merge_forward" {
+		// 引用合并转发的聊天记录时展开全部内容，而不是截断的引用
+		info.forwardId = info.parentId
+		return ""
+	}
+	if parent.SenderType == "app" && parent.MsgType == "interactive" {
+		// 会话已清除或卡片不属于当前会话
+		log.Printf("Quoted card %s not found in session %s, skip", info.parentId, sessionId)
+		return ""
+	}
+
+	text := quotedPlaceholders[parent.MsgType]
+	if content, err := parseMessageContent(parent.MsgType, parent.Content); err == nil && content.Text != "" {
+		text = stripMentions(content.Text, nil, handler.botOpenId)
+	}
+	if text == "" {
+		return ""
+	}
+
+	// 话题内回复会引用话题根消息，已在上下文中的消息无需重复
+	for _, msg := range history {
+		if msg.Role == "user" && msg.Content == text {
+			return ""
+		}
+	}
+	return text
+}
+
+// findAssistantReply finds the assistant text rendered in the card with the message ID
+func findAssistantReply(sessionCache core.SessionCache, sessionId string, history []ai.Message, cardId string) (string, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		if msg.Role == "assistant" && msg.Metadata["card_id"] == cardId {
+			return msg.Content, true
+		}
+	}
+
+	// 兼容未记录card_id的历史，使用最近一次回复
+	meta, ok := sessionCache.GetSessionMeta(sessionId)
+	if !ok || meta.CardId != cardId {
+		return "", false
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "assistant" {
+			return history[i].Content, true
+		}
+	}
+	return "", false
+}
+
+// withQuote prepends the quoted text to the query as a Markdown quote
+func withQuote(quoted string, query string) string {
+	if quoted == "" {
+		return query
+	}
+	if utf8.RuneCountInString(quoted) > MaxQuotedLength {
+		quoted = string([]rune(quoted)[:MaxQuotedLength]) + "..."
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(quoted, "\n") {
+		b.WriteString("> ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(query)
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"start-feishubot/services"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/core"
+	"strings"
+	"testing"
+)
+
+// fakeMessageReader serves messages by message ID
+type fakeMessageReader struct {
+	core.MessageReader
+	messages map[string]*core.FetchedMessage
+}
+
+func (f *fakeMessageReader) GetMessage(ctx context.Context, messageID string) (*core.FetchedMessage, error) {
+	msg, ok := f.messages[messageID]
+	if !ok {
+		return nil, errors.New("message not found")
+	}
+	return msg, nil
+}
+
+func TestGetQuotedContext(t *testing.T) {
+	sessionCache := services.GetSessionCache()
+	sessionId := "oc_quote_chat"
+	defer sessionCache.Clear(sessionId)
+	err := sessionCache.SetMessages(sessionId, "ou_quote_user", []ai.Message{
+		{Role: "user", Content: "什么是 Dify？"},
+		{Role: "assistant", Content: "Dify 是开源的 LLM 应用开发平台。", Metadata: map[string]string{"card_id": "om_card_1"}},
+	}, "om_card_1", "om_quote_1", "", "")
+	if err != nil {
+		t.Fatalf("SetMessages() error = %v", err)
+	}
+
+	handler := &MessageHandler{
+		sessionCache: sessionCache,
+		msgReader: &fakeMessageReader{messages: map[string]*core.FetchedMessage{
+			"om_text":    {MsgType: "text", Content: `{"text":" 明天开会\n"}`, SenderType: "user"},
+			"om_image":   {MsgType: "image", Content: `{"image_key":"img_v2_1"}`, SenderType: "user"},
+			"om_card_2":  {MsgType: "interactive", Content: `{}`, SenderType: "app"},
+			"om_history": {MsgType: "text", Content: `{"text":"什么是 Dify？"}`, SenderType: "user"},
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		parentId string
+		want     string
+	}{
+		{name: "No quote", want: ""},
+		{name: "Bot card in session", parentId: "om_card_1", want: "Dify 是开源的 LLM 应用开发平台。"},
+		{name: "Text message", parentId: "om_text", want: "明天开会"},
+		{name: "Image message", parentId: "om_image", want: "[图片]"},
+		{name: "Bot card not in session", parentId: "om_card_2", want: ""},
+		{name: "Already in history", parentId: "om_history", want: ""},
+		{name: "Unreadable message", parentId: "om_missing", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &MsgInfo{sessionId: &sessionId, parentId: tt.parentId}
+			if got := getQuotedContext(context.Background(), handler, info, sessionCache.GetMessages(sessionId)); got != tt.want {
+				t.Errorf("getQuotedContext() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithQuote(t *testing.T) {
+	tests := []struct {
+		name   string
+		quoted string
+		query  string
+		want   string
+	}{
+		{name: "No quote", query: "总结一下", want: "总结一下"},
+		{name: "Multiline quote", quoted: "第一行\n第二行", query: "总结一下", want: "> 第一行\n> 第二行\n\n总结一下"},
+		{
+			name:   "Long quote",
+			quoted: strings.Repeat("长", MaxQuotedLength+1),
+			query:  "总结一下",
+			want:   "> " + strings.Repeat("长", MaxQuotedLength) + "...\n\n总结一下",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withQuote(tt.quoted, tt.query); got != tt.want {
+				t.Errorf("withQuote() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
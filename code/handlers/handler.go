@@ -3,9 +3,11 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"log"
 	"start-feishubot/services/cardpool"
 	"start-feishubot/services/config"
 	"start-feishubot/services/core"
@@ -25,6 +27,10 @@ func NewMessageHandler(
 	msgCache core.MessageCache,
 	aiProvider core.AIProvider,
 	cardPool *cardpool.CardPool,
+	botOpenId string,
+	resources core.ResourceDownloader,
+	transcriber core.Transcriber,
+	msgReader core.MessageReader,
 ) *MessageHandler {
 	return &MessageHandler{
 		sessionCache: sessionCache,
@@ -32,6 +38,10 @@ func NewMessageHandler(
 		msgCache:    msgCache,
 		dify:        aiProvider,
 		cardPool:    cardPool,
+		botOpenId:   botOpenId,
+		resources:   resources,
+		transcriber: transcriber,
+		msgReader:   msgReader,
 	}
 }
 
@@ -55,6 +65,7 @@ func (m *MessageHandler) cardHandler(ctx context.Context, cardAction *larkcard.C
 	// Get handler for card kind
 	handler := GetCardHandler(cardMsg, m)
 	if handler == nil {
+		log.Printf("[Handlers] Ignoring unsupported card kind: %s", cardMsg.Kind)
 		return nil, nil
 	}
 
@@ -62,31 +73,110 @@ func (m *MessageHandler) cardHandler(ctx context.Context, cardAction *larkcard.C
 	return handler(ctx, cardAction)
 }
 
-// judgeIfMentionMe checks if the bot is mentioned
+// judgeIfMentionMe checks if the bot is mentioned, it is never mentioned
+// while its open_id is unknown
 func (m *MessageHandler) judgeIfMentionMe(mention []*larkim.MentionEvent) bool {
-	if len(mention) != 1 {
-		return false
+	return isBotMentioned(mention, m.botOpenId)
+}
+
+// botAddedHandler handles the bot being added to a group chat
+func (m *MessageHandler) botAddedHandler(ctx context.Context, event *larkim.P2ChatMemberBotAddedV1) error {
+	if event.Event == nil || event.Event.ChatId == nil {
+		return fmt.Errorf("empty bot added event")
+	}
+	log.Printf("[Handlers] Bot added to chat: %s", *event.Event.ChatId)
+	return nil
+}
+
+// chatDisbandedHandler clears the sessions and session scope of a disbanded
+// chat. Sessions in user scope span chats and are kept.
+func (m *MessageHandler) chatDisbandedHandler(ctx context.Context, event *larkim.P2ChatDisbandedV1) error {
+	if event.Event == nil || event.Event.ChatId == nil {
+		return fmt.Errorf("empty chat disbanded event")
 	}
-	return true
+	sessionIds := forgetChat(*event.Event.ChatId)
+	log.Printf("[Handlers] Chat disbanded, clearing %d sessions: %s", len(sessionIds), *event.Event.ChatId)
+	for _, sessionId := range sessionIds {
+		m.sessionCache.Clear(sessionId)
+	}
+	return nil
 }
 
 // Handler handles HTTP requests
 func Handler(c *gin.Context) error {
-	// Get event type
-	var event struct {
-		Type string `json:"type"`
+	body, err := c.GetRawData()
+	if err != nil {
+		return err
 	}
-	if err := c.ShouldBindJSON(&event); err != nil {
+
+	// Get event envelope
+	var envelope EventEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
 		return err
 	}
 
 	// Handle URL verification
-	if event.Type == "url_verification" {
-		body, err := c.GetRawData()
+	if envelope.Type == "url_verification" {
+		result, err := VerifyURL(body, globalConfig)
+		if err != nil {
+			return err
+		}
+
+		c.JSON(200, result)
+		return nil
+	}
+
+	// Only schema 2.0 events carry header.event_type
+	if envelope.Schema != "2.0" {
+		log.Printf("[Handlers] Ignoring event with unsupported schema: %q, type: %q", envelope.Schema, envelope.Type)
+		c.JSON(200, gin.H{"msg": "success"})
+		return nil
+	}
+
+	if messageHandler == nil {
+		return fmt.Errorf("handlers not initialized")
+	}
+
+	result, err := DispatchEvent(c.Request.Context(), messageHandler, &envelope, body)
+	if err != nil {
+		return err
+	}
+
+	writeCallbackResult(c, result)
+	return nil
+}
+
+// CardHandler handles card action callback requests, both schema 2.0
+// card.action.trigger events and legacy card actions
+func CardHandler(c *gin.Context) error {
+	body, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+
+	var envelope EventEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return err
+	}
+	if envelope.Schema == "2.0" {
+		if messageHandler == nil {
+			return fmt.Errorf("handlers not initialized")
+		}
+		result, err := DispatchEvent(c.Request.Context(), messageHandler, &envelope, body)
 		if err != nil {
 			return err
 		}
+		writeCallbackResult(c, result)
+		return nil
+	}
+
+	var cardAction larkcard.CardAction
+	if err := json.Unmarshal(body, &cardAction); err != nil {
+		return err
+	}
 
+	// Handle URL verification
+	if cardAction.Type == "url_verification" {
 		result, err := VerifyURL(body, globalConfig)
 		if err != nil {
 			return err
@@ -96,6 +186,42 @@ func Handler(c *gin.Context) error {
 		return nil
 	}
 
-	// Handle other events
+	if token := globalConfig.GetFeishuAppVerificationToken(); token != "" && cardAction.Token != token {
+		return fmt.Errorf("invalid card action token")
+	}
+	if cardAction.Action == nil {
+		return fmt.Errorf("empty card action")
+	}
+
+	if messageHandler == nil {
+		return fmt.Errorf("handlers not initialized")
+	}
+
+	result, err := messageHandler.cardHandler(c.Request.Context(), &cardAction)
+	if err != nil {
+		return err
+	}
+
+	writeCallbackResult(c, result)
 	return nil
 }
+
+// writeCallbackResult writes a handler result as the callback response.
+// A nil result acknowledges the callback, a card updates the original card,
+// and a CustomResp carries a toast.
+func writeCallbackResult(c *gin.Context, result interface{}) {
+	switch r := result.(type) {
+	case nil:
+		c.JSON(200, gin.H{"msg": "success"})
+	case string:
+		c.Data(200, "application/json; charset=utf-8", []byte(r))
+	case *larkcard.CustomResp:
+		statusCode := r.StatusCode
+		if statusCode == 0 {
+			statusCode = 200
+		}
+		c.JSON(statusCode, r.Body)
+	default:
+		c.JSON(200, r)
+	}
+}
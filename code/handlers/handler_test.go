@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"net/http"
+	"net/http/httptest"
+	"start-feishubot/services/config"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCardHandlerV2Callback(t *testing.T) {
+	const testKind CardKind = "test_v2_callback"
+
+	cfg := &config.ConfigImpl{
+		FeishuAppVerificationToken: "test-token",
+		FeishuAppEncryptKey:        "test-key",
+	}
+	oldConfig, oldHandler := globalConfig, messageHandler
+	globalConfig, messageHandler = cfg, &MessageHandler{}
+	defer func() { globalConfig, messageHandler = oldConfig, oldHandler }()
+
+	var got *larkcard.CardAction
+	cardHandlerMap[testKind] = func(cardMsg CardMsg, m *MessageHandler) CardHandlerFunc {
+		return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+			got = cardAction
+			return nil, nil
+		}
+	}
+	defer delete(cardHandlerMap, testKind)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/card", VerifyMiddleware(cfg, VerifyCardCallback), func(c *gin.Context) {
+		if err := CardHandler(c); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	})
+
+	body := `{"schema":"2.0","header":{"event_id":"ev1","token":"test-token","event_type":"card.action.trigger"},` +
+		`"event":{"operator":{"open_id":"ou_1"},"token":"c-1","action":{"tag":"button","value":{"Kind":"test_v2_callback"}},` +
+		`"context":{"open_message_id":"om_1","open_chat_id":"oc_1"}}}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := sha256.Sum256([]byte(timestamp + "nonce" + cfg.FeishuAppEncryptKey + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook/card", strings.NewReader(body))
+	req.Header.Set("X-Lark-Request-Timestamp", timestamp)
+	req.Header.Set("X-Lark-Request-Nonce", "nonce")
+	req.Header.Set("X-Lark-Signature", fmt.Sprintf("%x", signature))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if got == nil {
+		t.Fatal("card handler was not called")
+	}
+	if got.OpenID != "ou_1" || got.OpenMessageID != "om_1" {
+		t.Errorf("card action = %+v, want open_id ou_1 and open_message_id om_1", got)
+	}
+}
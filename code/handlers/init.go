@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"context"
 	"log"
 	"start-feishubot/initialization"
+	"start-feishubot/services/core"
+	"start-feishubot/services/stt"
+	"sync"
 	"time"
 )
 
 var (
 	messageHandler *MessageHandler
+	// background tracks the messages answered after their callback was acknowledged
+	background sync.WaitGroup
 )
 
 // InitHandlers initializes all handlers
@@ -22,6 +28,19 @@ func InitHandlers() error {
 	msgCache := initialization.GetMsgCache()
 	aiProvider := initialization.GetAIProvider()
 	cardPool := initialization.GetCardPool()
+	resources := initialization.GetResourceDownloader()
+	// 语音识别在此创建，避免initialization依赖openai包形成测试导入循环
+	var transcriber core.Transcriber
+	if t, err := stt.NewTranscriber(initialization.GetConfig()); err != nil {
+		log.Printf("[Handlers] Failed to initialize speech to text, audio messages are disabled: %v", err)
+	} else {
+		transcriber = t
+	}
+	msgReader := initialization.GetMessageReader()
+	botOpenId := ""
+	if botInfo := initialization.GetBotInfo(); botInfo != nil {
+		botOpenId = botInfo.OpenID
+	}
 	log.Printf("[Handlers] All required services retrieved")
 
 	// Create message handler
@@ -32,6 +51,10 @@ func InitHandlers() error {
 		msgCache:    msgCache,
 		dify:        aiProvider,
 		cardPool:    cardPool,
+		botOpenId:   botOpenId,
+		resources:   resources,
+		transcriber: transcriber,
+		msgReader:   msgReader,
 	}
 	log.Printf("[Handlers] Message handler created")
 
@@ -39,7 +62,29 @@ func InitHandlers() error {
 	return nil
 }
 
-// Shutdown performs cleanup
-func Shutdown() {
-	// Add cleanup code here
+// goBackground runs f in the background, Shutdown waits for it to return
+func goBackground(f func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+// Shutdown waits for the messages being answered in the background, at
+// most until ctx is done
+func Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("[Handlers] All background answers finished")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
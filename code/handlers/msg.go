@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
-	"start-feishubot/services/config"
+	"sort"
+	"strings"
 )
 
 // GetTextContent extracts text content from message
@@ -55,13 +56,103 @@ func GetFileContent(msg *larkim.EventMessage) (string, error) {
 	return fileContent.FileKey, nil
 }
 
-// GetMentionedMessage extracts mentioned message from event
-func GetMentionedMessage(event *larkim.P2MessageReceiveV1, cfg config.Config) bool {
-	mentions := event.Event.Message.Mentions
-	if len(mentions) != 1 {
+// MessageContent is the parsed content of a received message
+type MessageContent struct {
+	Text      string
+	ImageKeys []string
+	AudioKey  string
+	FileKey   string
+	FileName  string
+	// MergeForward marks a merged forward, whose messages are read through the IM API
+	MergeForward bool
+}
+
+// parseMessageContent extracts the text and resource keys of a message by its type
+func parseMessageContent(msgType string, content string) (*MessageContent, error) {
+	switch msgType {
+	case "text":
+		text, err := GetTextContent(&larkim.EventMessage{Content: &content})
+		if err != nil {
+			return nil, err
+		}
+		return &MessageContent{Text: text}, nil
+	case "image":
+		imageKey, err := GetImageContent(&larkim.EventMessage{Content: &content})
+		if err != nil {
+			return nil, err
+		}
+		return &MessageContent{ImageKeys: []string{imageKey}}, nil
+	case "audio":
+		audioKey, err := GetAudioContent(&larkim.EventMessage{Content: &content})
+		if err != nil {
+			return nil, err
+		}
+		return &MessageContent{AudioKey: audioKey}, nil
+	case "file":
+		var file struct {
+			FileKey  string `json:"file_key"`
+			FileName string `json:"file_name"`
+		}
+		if err := json.Unmarshal([]byte(content), &file); err != nil {
+			return nil, err
+		}
+		return &MessageContent{FileKey: file.FileKey, FileName: file.FileName}, nil
+	case "merge_forward":
+		return &MessageContent{MergeForward: true}, nil
+	case "post":
+		post, err := PostToMarkdown(content)
+		if err != nil {
+			return nil, err
+		}
+		return &MessageContent{Text: post.Markdown, ImageKeys: post.ImageKeys}, nil
+	default:
+		return nil, fmt.Errorf("unsupported message type: %s", msgType)
+	}
+}
+
+// GetMentionedMessage checks if the bot is mentioned in the event
+func GetMentionedMessage(event *larkim.P2MessageReceiveV1, botOpenId string) bool {
+	return isBotMentioned(event.Event.Message.Mentions, botOpenId)
+}
+
+// isBotMentioned checks if any mention refers to the bot's open_id
+func isBotMentioned(mentions []*larkim.MentionEvent, botOpenId string) bool {
+	if botOpenId == "" {
 		return false
 	}
-	return *mentions[0].Name == cfg.GetFeishuAppID()
+	for _, mention := range mentions {
+		if mention.Id != nil && mention.Id.OpenId != nil && *mention.Id.OpenId == botOpenId {
+			return true
+		}
+	}
+	return false
+}
+
+// stripMentions replaces the @_user_N placeholders of a text message using the
+// mention keys: the bot mention is removed and other mentions become @Name
+func stripMentions(text string, mentions []*larkim.MentionEvent, botOpenId string) string {
+	keyed := make([]*larkim.MentionEvent, 0, len(mentions))
+	for _, mention := range mentions {
+		if mention.Key != nil && *mention.Key != "" {
+			keyed = append(keyed, mention)
+		}
+	}
+	// 长的占位符优先匹配，避免@_user_1替换掉@_user_10的前缀
+	sort.SliceStable(keyed, func(i, j int) bool {
+		return len(*keyed[i].Key) > len(*keyed[j].Key)
+	})
+
+	oldnew := make([]string, 0, 2*len(keyed))
+	for _, mention := range keyed {
+		replacement := ""
+		isBot := mention.Id != nil && mention.Id.OpenId != nil && *mention.Id.OpenId == botOpenId
+		if !isBot && mention.Name != nil {
+			replacement = "@" + *mention.Name
+		}
+		oldnew = append(oldnew, *mention.Key, replacement)
+	}
+	// 一次替换，替换后的名字中的占位符不会再被替换
+	return strings.TrimSpace(strings.NewReplacer(oldnew...).Replace(text))
 }
 
 // GetMessageType extracts message type from event
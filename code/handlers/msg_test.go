@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const testBotOpenId = "ou_bot"
+
+// newTestMention builds the mention of a text message
+func newTestMention(key string, openId string, name string) *larkim.MentionEvent {
+	return &larkim.MentionEvent{
+		Key:  &key,
+		Id:   &larkim.UserId{OpenId: &openId},
+		Name: &name,
+	}
+}
+
+func TestIsBotMentioned(t *testing.T) {
+	bot := newTestMention("@_user_1", testBotOpenId, "Bot")
+	other := newTestMention("@_user_2", "ou_other", "张三")
+	tests := []struct {
+		name      string
+		mentions  []*larkim.MentionEvent
+		botOpenId string
+		want      bool
+	}{
+		{"no mentions", nil, testBotOpenId, false},
+		{"bot", []*larkim.MentionEvent{bot}, testBotOpenId, true},
+		{"other member", []*larkim.MentionEvent{other}, testBotOpenId, false},
+		{"bot among others", []*larkim.MentionEvent{other, bot}, testBotOpenId, true},
+		{"unknown bot", []*larkim.MentionEvent{other}, "", false},
+		{"mention without id", []*larkim.MentionEvent{{Key: bot.Key}}, testBotOpenId, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBotMentioned(tt.mentions, tt.botOpenId); got != tt.want {
+				t.Errorf("isBotMentioned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripMentions(t *testing.T) {
+	var mentions []*larkim.MentionEvent
+	for i, name := range []string{"Bot", "用户1", "用户2", "用户3", "用户4", "用户5", "用户6", "用户7", "用户8", "用户9", "用户10"} {
+		openId := "ou_" + name
+		if i == 0 {
+			openId = testBotOpenId
+		}
+		mentions = append(mentions, newTestMention("@_user_"+strconv.Itoa(i+1), openId, name))
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		mentions []*larkim.MentionEvent
+		want     string
+	}{
+		{"bot removed", "@_user_1 你好", mentions[:1], "你好"},
+		{"member named", "@_user_1 问问 @_user_2", mentions[:2], "问问 @用户1"},
+		{"longer key", "@_user_1 @_user_2 和 @_user_11", mentions, "@用户1 和 @用户10"},
+		{"name with placeholder", "@_user_1 @_user_2", []*larkim.MentionEvent{
+			newTestMention("@_user_1", "ou_1", "@_user_2"),
+			newTestMention("@_user_2", testBotOpenId, "Bot"),
+		}, "@@_user_2"},
+		{"no mentions", "你好", nil, "你好"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripMentions(tt.text, tt.mentions, testBotOpenId); got != tt.want {
+				t.Errorf("stripMentions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMessageContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgType string
+		content string
+		want    *MessageContent
+		wantErr bool
+	}{
+		{
+			name:    "Text",
+			msgType: "text",
+			content: `{"text":"@_user_1 你好"}`,
+			want:    &MessageContent{Text: "@_user_1 你好"},
+		},
+		{
+			name:    "Image",
+			msgType: "image",
+			content: `{"image_key":"img_v2_1"}`,
+			want:    &MessageContent{ImageKeys: []string{"img_v2_1"}},
+		},
+		{
+			name:    "Audio",
+			msgType: "audio",
+			content: `{"file_key":"file_v2_1","duration":2000}`,
+			want:    &MessageContent{AudioKey: "file_v2_1"},
+		},
+		{
+			name:    "Post with images",
+			msgType: "post",
+			content: `{"title":"报错截图","content":[` +
+				`[{"tag":"at","user_id":"@_user_1","user_name":"Bot"},{"tag":"text","text":" 这是什么错误？"}],` +
+				`[{"tag":"img","image_key":"img_v2_1"},{"tag":"img","image_key":"img_v2_2"}],` +
+				`[{"tag":"a","text":"文档","href":"https://example.com"}]]}`,
+			want: &MessageContent{
+				Text:      "# 报错截图\n@_user_1 这是什么错误？\n\n[文档](https://example.com)",
+				ImageKeys: []string{"img_v2_1", "img_v2_2"},
+			},
+		},
+		{
+			name:    "Unsupported type",
+			msgType: "sticker",
+			content: `{"file_key":"sticker_1"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Malformed content",
+			msgType: "text",
+			content: `not json`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMessageContent(tt.msgType, tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMessageContent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMessageContent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PostElement is an element of a rich text (post) message
+type PostElement struct {
+	Tag      string   `json:"tag"`
+	Text     string   `json:"text"`
+	Href     string   `json:"href"`
+	UserId   string   `json:"user_id"`
+	UserName string   `json:"user_name"`
+	ImageKey string   `json:"image_key"`
+	FileKey  string   `json:"file_key"`
+	Language string   `json:"language"`
+	Emoji    string   `json:"emoji_type"`
+	Style    []string `json:"style"`
+}
+
+// PostContent is the content of a rich text (post) message
+type PostContent struct {
+	Title   string          `json:"title"`
+	Content [][]PostElement `json:"content"`
+}
+
+// PostMarkdown is a post message converted to Markdown with its attachment keys
+type PostMarkdown struct {
+	Markdown  string
+	ImageKeys []string
+	FileKeys  []string
+}
+
+// PostToMarkdown converts the JSON content of a post message to Markdown.
+// Both the plain structure of message events and the locale wrapped structure
+// ({"zh_cn": {...}}) are supported.
+func PostToMarkdown(content string) (*PostMarkdown, error) {
+	post, err := parsePostContent(content)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PostMarkdown{}
+	var paragraphs []string
+	if title := strings.TrimSpace(post.Title); title != "" {
+		paragraphs = append(paragraphs, "# "+title)
+	}
+	for _, paragraph := range post.Content {
+		var line strings.Builder
+		for _, element := range paragraph {
+			switch element.Tag {
+			case "text":
+				line.WriteString(applyPostStyle(element.Text, element.Style))
+			case "a":
+				text := element.Text
+				if text == "" {
+					text = element.Href
+				}
+				line.WriteString(fmt.Sprintf("[%s](%s)", applyPostStyle(text, element.Style), element.Href))
+			case "at":
+				// 保留@占位符，由stripMentions统一替换
+				if element.UserId == "all" {
+					line.WriteString("@所有人")
+				} else {
+					line.WriteString(element.UserId)
+				}
+			case "img":
+				if element.ImageKey != "" {
+					result.ImageKeys = append(result.ImageKeys, element.ImageKey)
+				}
+			case "media":
+				if element.FileKey != "" {
+					result.FileKeys = append(result.FileKeys, element.FileKey)
+				}
+			case "emotion":
+				line.WriteString(":" + element.Emoji + ":")
+			case "code_block":
+				if line.Len() > 0 {
+					line.WriteString("\n")
+				}
+				line.WriteString("```" + strings.ToLower(element.Language) + "\n")
+				line.WriteString(strings.TrimRight(element.Text, "\n"))
+				line.WriteString("\n```")
+			case "hr":
+				line.WriteString("---")
+			case "md":
+				line.WriteString(element.Text)
+			}
+		}
+		paragraphs = append(paragraphs, line.String())
+	}
+
+	result.Markdown = strings.TrimSpace(strings.Join(paragraphs, "\n"))
+	return result, nil
+}
+
+// parsePostContent unmarshals post content, unwrapping the locale layer if present
+func parsePostContent(content string) (*PostContent, error) {
+	var post PostContent
+	if err := json.Unmarshal([]byte(content), &post); err != nil {
+		return nil, err
+	}
+	if post.Title != "" || len(post.Content) > 0 {
+		return &post, nil
+	}
+
+	var locales map[string]PostContent
+	if err := json.Unmarshal([]byte(content), &locales); err != nil {
+		return &post, nil
+	}
+	for _, locale := range []string{"zh_cn", "en_us", "ja_jp"} {
+		if p, ok := locales[locale]; ok {
+			return &p, nil
+		}
+	}
+	for _, p := range locales {
+		return &p, nil
+	}
+	return &post, nil
+}
+
+// applyPostStyle wraps text with the Markdown marks of its styles
+func applyPostStyle(text string, styles []string) string {
+	if strings.TrimSpace(text) == "" {
+		return text
+	}
+	for _, style := range styles {
+		switch style {
+		case "bold":
+			text = "**" + text + "**"
+		case "italic":
+			text = "*" + text + "*"
+		case "lineThrough":
+			text = "~~" + text + "~~"
+		}
+	}
+	return text
+}
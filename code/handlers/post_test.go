@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostToMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *PostMarkdown
+		wantErr bool
+	}{
+		{
+			name:    "Title and styled text",
+			content: `{"title":"周报","content":[[{"tag":"text","text":"重点","style":["bold"]},{"tag":"text","text":" 进度正常"}]]}`,
+			want: &PostMarkdown{
+				Markdown: "# 周报\n**重点** 进度正常",
+			},
+		},
+		{
+			name:    "Link and mention",
+			content: `{"title":"","content":[[{"tag":"at","user_id":"@_user_1","user_name":"Bot"},{"tag":"text","text":" 看下 "},{"tag":"a","text":"文档","href":"https://example.com"}]]}`,
+			want: &PostMarkdown{
+				Markdown: "@_user_1 看下 [文档](https://example.com)",
+			},
+		},
+		{
+			name:    "Code block",
+			content: `{"title":"","content":[[{"tag":"text","text":"为什么报错"}],[{"tag":"code_block","language":"GO","text":"fmt.Println(1)\n"}]]}`,
+			want: &PostMarkdown{
+				Markdown: "为什么报错\n```go\nfmt.Println(1)\n```",
+			},
+		},
+		{
+			name:    "Images and media",
+			content: `{"title":"","content":[[{"tag":"img","image_key":"img_1"},{"tag":"text","text":"这是什么"}],[{"tag":"media","file_key":"file_1","image_key":"img_cover"}]]}`,
+			want: &PostMarkdown{
+				Markdown:  "这是什么",
+				ImageKeys: []string{"img_1"},
+				FileKeys:  []string{"file_1"},
+			},
+		},
+		{
+			name:    "Locale wrapped",
+			content: `{"zh_cn":{"title":"标题","content":[[{"tag":"text","text":"正文","style":["italic","lineThrough"]}]]}}`,
+			want: &PostMarkdown{
+				Markdown: "# 标题\n~~*正文*~~",
+			},
+		},
+		{
+			name:    "Invalid JSON",
+			content: `{"title":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PostToMarkdown(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PostToMarkdown() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PostToMarkdown() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
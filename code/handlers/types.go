@@ -6,6 +6,7 @@ import (
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 	"start-feishubot/services/cardpool"
+	"start-feishubot/services/cardtemplate"
 	"start-feishubot/services/core"
 )
 
@@ -16,6 +17,10 @@ type MessageHandler struct {
 	msgCache     core.MessageCache
 	dify         core.AIProvider
 	cardPool     *cardpool.CardPool
+	botOpenId    string
+	resources    core.ResourceDownloader
+	transcriber  core.Transcriber
+	msgReader    core.MessageReader
 }
 
 // MessageHandlerInterface defines the interface for message handlers
@@ -36,21 +41,22 @@ const (
 )
 
 // CardKind defines the type of card
-type CardKind string
+type CardKind = cardtemplate.CardKind
 
 // Card kinds
 const (
-	ClearCardKind      CardKind = "clear"
-	PicModeChangeKind  CardKind = "pic_mode_change"
-	PicResolutionKind  CardKind = "pic_resolution"
-	PicTextMoreKind    CardKind = "pic_text_more"
-	PicVarMoreKind     CardKind = "pic_var_more"
-	RoleTagsChooseKind CardKind = "role_tags_choose"
-	RoleChooseKind     CardKind = "role_choose"
+	ClearCardKind         CardKind = "clear"
+	PicModeChangeKind     CardKind = "pic_mode_change"
+	PicResolutionKind     CardKind = "pic_resolution"
+	PicTextMoreKind       CardKind = "pic_text_more"
+	PicVarMoreKind        CardKind = "pic_var_more"
+	RoleTagsChooseKind    CardKind = "role_tags_choose"
+	RoleChooseKind        CardKind = "role_choose"
+	SuggestedQuestionKind CardKind = "suggested_question"
 )
 
 // CardChatType defines the type of chat
-type CardChatType string
+type CardChatType = cardtemplate.CardChatType
 
 // Chat types
 const (
@@ -59,13 +65,7 @@ const (
 )
 
 // CardMsg represents a card message
-type CardMsg struct {
-	Kind      CardKind
-	ChatType  CardChatType
-	SessionId string
-	MsgId     string
-	Value     interface{}
-}
+type CardMsg = cardtemplate.CardMsg
 
 // CardHandlerFunc defines the function type for handling card actions
 type CardHandlerFunc func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error)
@@ -86,8 +86,14 @@ type MsgInfo struct {
 	msgType     string
 	sessionId   *string
 	msgId       *string
+	replyTo     string // 回复的消息ID，为空时回复msgId
 	chatId      string
 	qParsed     string
+	imageKeys   []string
+	audioKey    string
+	parentId    string
+	rootId      string
+	forwardId   string
 	userId      string
 	mention     []*larkim.MentionEvent
 }
@@ -125,3 +131,22 @@ func judgeChatType(event *larkim.P2MessageReceiveV1) HandlerType {
 		return OtherHandler
 	}
 }
+
+// NewCardMsg creates a card message bound to the session of the message,
+// so that card actions operate on the same session scope
+func NewCardMsg(kind CardKind, info *MsgInfo, value interface{}) CardMsg {
+	chatType := UserChatType
+	if info.handlerType == GroupHandler {
+		chatType = GroupChatType
+	}
+	cardMsg := CardMsg{
+		Kind:      kind,
+		ChatType:  chatType,
+		SessionId: *info.sessionId,
+		Value:     value,
+	}
+	if info.msgId != nil {
+		cardMsg.MsgId = *info.msgId
+	}
+	return cardMsg
+}
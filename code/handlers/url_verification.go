@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"start-feishubot/services/config"
+	"strconv"
+	"time"
 )
 
 // Challenge represents the challenge request
@@ -35,24 +39,104 @@ func VerifyURL(body []byte, cfg config.Config) (interface{}, error) {
 	}, nil
 }
 
-// VerifyRequest verifies the request signature
+// MaxRequestTimestampSkew is the maximum allowed difference between the
+// request timestamp and the local clock
+const MaxRequestTimestampSkew = 5 * time.Minute
+
+// VerifyRequest verifies the event request signature computed from the encrypt key
 func VerifyRequest(r *http.Request, body []byte, cfg config.Config) error {
+	encryptKey := cfg.GetFeishuAppEncryptKey()
+	if encryptKey == "" {
+		// 未配置Encrypt Key时飞书不会对事件签名
+		return nil
+	}
+
 	// Get signature
 	signature := r.Header.Get("X-Lark-Signature")
 	if signature == "" {
 		return fmt.Errorf("missing signature")
 	}
 
+	timestamp := r.Header.Get("X-Lark-Request-Timestamp")
+	if err := verifyTimestamp(timestamp); err != nil {
+		return err
+	}
+
 	// Calculate expected signature
+	nonce := r.Header.Get("X-Lark-Request-Nonce")
+	expected := sha256.Sum256([]byte(fmt.Sprintf("%s%s%s%s", timestamp, nonce, encryptKey, string(body))))
+
+	// Verify signature
+	if !hmac.Equal([]byte(fmt.Sprintf("%x", expected)), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
+
+// VerifyCardRequest verifies the card callback signature computed from the verification token
+func VerifyCardRequest(r *http.Request, body []byte, cfg config.Config) error {
 	token := cfg.GetFeishuAppVerificationToken()
+	if token == "" {
+		return nil
+	}
+
+	// Get signature
+	signature := r.Header.Get("X-Lark-Signature")
+	if signature == "" {
+		return fmt.Errorf("missing signature")
+	}
+
 	timestamp := r.Header.Get("X-Lark-Request-Timestamp")
+	if err := verifyTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	// Calculate expected signature
 	nonce := r.Header.Get("X-Lark-Request-Nonce")
-	expected := sha256.Sum256([]byte(fmt.Sprintf("%s%s%s%s", timestamp, nonce, token, string(body))))
+	expected := sha1.Sum([]byte(fmt.Sprintf("%s%s%s%s", timestamp, nonce, token, string(body))))
 
 	// Verify signature
-	if fmt.Sprintf("%x", expected) != signature {
+	if !hmac.Equal([]byte(fmt.Sprintf("%x", expected)), []byte(signature)) {
 		return fmt.Errorf("invalid signature")
 	}
 
 	return nil
 }
+
+// VerifyCardCallback verifies a card callback. Schema 2.0 callbacks
+// (card.action.trigger) are signed like events with the encrypt key,
+// legacy callbacks with the verification token.
+func VerifyCardCallback(r *http.Request, body []byte, cfg config.Config) error {
+	plain, err := decryptBody(body, cfg)
+	if err != nil {
+		return err
+	}
+	var envelope EventEnvelope
+	if err := json.Unmarshal(plain, &envelope); err != nil {
+		return err
+	}
+	if envelope.Schema == "2.0" {
+		return VerifyRequest(r, body, cfg)
+	}
+	return VerifyCardRequest(r, body, cfg)
+}
+
+// verifyTimestamp rejects replayed requests whose timestamp is too far from now
+func verifyTimestamp(timestamp string) error {
+	if timestamp == "" {
+		return fmt.Errorf("missing timestamp")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %s", timestamp)
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > MaxRequestTimestampSkew {
+		return fmt.Errorf("request timestamp expired: skew %v", skew)
+	}
+	return nil
+}
@@ -15,7 +15,7 @@ var (
 	aiOnce     sync.Once
 )
 
-// InitAIProvider initializes the AI provider
+// InitAIProvider initializes the Dify chat provider
 func InitAIProvider() (ai.Provider, error) {
 	var initErr error
 	aiOnce.Do(func() {
@@ -65,11 +65,11 @@ func ShutdownAIProvider() error {
 	return nil
 }
 
-// StreamChat implements ai.Provider interface for testing
+// StreamChat streams the answer text of the AI provider for testing
 func StreamChat(ctx context.Context, messages []ai.Message, responseStream chan string) error {
 	provider := GetAIProvider()
 	if provider == nil {
 		return errors.New("AI provider not available")
 	}
-	return provider.StreamChat(ctx, messages, responseStream)
+	return ai.StreamText(ctx, provider, messages, responseStream)
 }
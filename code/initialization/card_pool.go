@@ -13,13 +13,13 @@ var (
 )
 
 // InitCardPool 初始化卡片池
-func InitCardPool(createCardFn cardpool.CreateCardFn) error {
+func InitCardPool(createCardFn cardpool.CreateCardFn, config cardpool.Config) error {
 	cardPoolOnce.Do(func() {
 		log.Printf("[CardPool Init] ===== Starting card pool initialization =====")
 		startTime := time.Now()
 		
 		log.Printf("[CardPool Init] Creating new card pool instance")
-		cardPoolInstance = cardpool.NewCardPool(createCardFn)
+		cardPoolInstance = cardpool.NewCardPoolWithConfig(createCardFn, config)
 		
 		log.Printf("[CardPool Init] ===== Card pool initialization completed in %v, size: %d =====", 
 			time.Since(startTime), 
@@ -31,6 +31,7 @@ func InitCardPool(createCardFn cardpool.CreateCardFn) error {
 // ShutdownCardPool 关闭卡片池
 func ShutdownCardPool() {
 	if cardPoolInstance != nil {
+		log.Printf("[CardPool Init] Card pool stats: %+v", cardPoolInstance.Stats())
 		cardPoolInstance.Stop()
 		cardPoolInstance = nil
 	}
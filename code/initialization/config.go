@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"start-feishubot/services/config"
 	"time"
 )
@@ -15,9 +16,24 @@ type ConfigImpl struct {
 	FeishuAppID                 string `json:"feishu_app_id"`
 	FeishuAppSecret            string `json:"feishu_app_secret"`
 	FeishuAppVerificationToken string `json:"feishu_app_verification_token"`
+	FeishuAppEncryptKey        string `json:"feishu_app_encrypt_key"`
 	DifyAPIEndpoint            string `json:"dify_api_endpoint"`
 	DifyAPIKey                 string `json:"dify_api_key"`
 	HttpPort                   string `json:"http_port"`
+	SessionScope               string `json:"session_scope"`
+	GroupReplyMode             string `json:"group_reply_mode"`
+	GroupKeywordPrefix         string `json:"group_keyword_prefix"`
+	SpeechToText               string `json:"speech_to_text"`
+	OpenaiApiKey               string `json:"openai_api_key"`
+	OpenaiApiUrl               string `json:"openai_api_url"`
+	TranscriptChunkSize        int    `json:"transcript_chunk_size"`
+	CardMode                   string `json:"card_mode"`
+	CardUpdateIntervalMs       int    `json:"card_update_interval_ms"`
+	CardUpdateMinDelta         int    `json:"card_update_min_delta"`
+	CardUpdateQPS              float64 `json:"card_update_qps"`
+	CardPoolMinSize            int    `json:"card_pool_min_size"`
+	CardPoolMaxSize            int    `json:"card_pool_max_size"`
+	AgentThoughts              string `json:"agent_thoughts"`
 	Initialized               bool   `json:"-"`
 }
 
@@ -65,9 +81,36 @@ func loadConfig() error {
 	globalConfig.FeishuAppID = os.Getenv("FEISHU_APP_ID")
 	globalConfig.FeishuAppSecret = os.Getenv("FEISHU_APP_SECRET")
 	globalConfig.FeishuAppVerificationToken = os.Getenv("FEISHU_APP_VERIFICATION_TOKEN")
+	globalConfig.FeishuAppEncryptKey = os.Getenv("FEISHU_APP_ENCRYPT_KEY")
 	globalConfig.DifyAPIEndpoint = os.Getenv("DIFY_API_ENDPOINT")
 	globalConfig.DifyAPIKey = os.Getenv("DIFY_API_KEY")
 	globalConfig.HttpPort = os.Getenv("HTTP_PORT")
+	globalConfig.SessionScope = os.Getenv("SESSION_SCOPE")
+	globalConfig.GroupReplyMode = os.Getenv("GROUP_REPLY_MODE")
+	globalConfig.GroupKeywordPrefix = os.Getenv("GROUP_KEYWORD_PREFIX")
+	globalConfig.SpeechToText = os.Getenv("SPEECH_TO_TEXT")
+	globalConfig.OpenaiApiKey = os.Getenv("OPENAI_API_KEY")
+	globalConfig.OpenaiApiUrl = os.Getenv("OPENAI_API_URL")
+	globalConfig.CardMode = os.Getenv("CARD_MODE")
+	globalConfig.AgentThoughts = os.Getenv("AGENT_THOUGHTS")
+	if size, err := strconv.Atoi(os.Getenv("TRANSCRIPT_CHUNK_SIZE")); err == nil {
+		globalConfig.TranscriptChunkSize = size
+	}
+	if interval, err := strconv.Atoi(os.Getenv("CARD_UPDATE_INTERVAL_MS")); err == nil {
+		globalConfig.CardUpdateIntervalMs = interval
+	}
+	if delta, err := strconv.Atoi(os.Getenv("CARD_UPDATE_MIN_DELTA")); err == nil {
+		globalConfig.CardUpdateMinDelta = delta
+	}
+	if qps, err := strconv.ParseFloat(os.Getenv("CARD_UPDATE_QPS"), 64); err == nil {
+		globalConfig.CardUpdateQPS = qps
+	}
+	if size, err := strconv.Atoi(os.Getenv("CARD_POOL_MIN_SIZE")); err == nil {
+		globalConfig.CardPoolMinSize = size
+	}
+	if size, err := strconv.Atoi(os.Getenv("CARD_POOL_MAX_SIZE")); err == nil {
+		globalConfig.CardPoolMaxSize = size
+	}
 	if globalConfig.HttpPort == "" {
 		globalConfig.HttpPort = "8080"
 		log.Printf("[Config] Using default HTTP port: %s", globalConfig.HttpPort)
@@ -91,6 +134,10 @@ func (c *ConfigImpl) GetFeishuAppVerificationToken() string {
 	return c.FeishuAppVerificationToken
 }
 
+func (c *ConfigImpl) GetFeishuAppEncryptKey() string {
+	return c.FeishuAppEncryptKey
+}
+
 func (c *ConfigImpl) GetDifyAPIEndpoint() string {
 	return c.DifyAPIEndpoint
 }
@@ -103,6 +150,62 @@ func (c *ConfigImpl) GetHttpPort() string {
 	return c.HttpPort
 }
 
+func (c *ConfigImpl) GetSessionScope() string {
+	return c.SessionScope
+}
+
+func (c *ConfigImpl) GetGroupReplyMode() string {
+	return c.GroupReplyMode
+}
+
+func (c *ConfigImpl) GetGroupKeywordPrefix() string {
+	return c.GroupKeywordPrefix
+}
+
+func (c *ConfigImpl) GetSpeechToText() string {
+	return c.SpeechToText
+}
+
+func (c *ConfigImpl) GetOpenaiApiKey() string {
+	return c.OpenaiApiKey
+}
+
+func (c *ConfigImpl) GetOpenaiApiUrl() string {
+	return c.OpenaiApiUrl
+}
+
+func (c *ConfigImpl) GetTranscriptChunkSize() int {
+	return c.TranscriptChunkSize
+}
+
+func (c *ConfigImpl) GetCardMode() string {
+	return c.CardMode
+}
+
+func (c *ConfigImpl) GetCardUpdateIntervalMs() int {
+	return c.CardUpdateIntervalMs
+}
+
+func (c *ConfigImpl) GetCardUpdateMinDelta() int {
+	return c.CardUpdateMinDelta
+}
+
+func (c *ConfigImpl) GetCardUpdateQPS() float64 {
+	return c.CardUpdateQPS
+}
+
+func (c *ConfigImpl) GetCardPoolMinSize() int {
+	return c.CardPoolMinSize
+}
+
+func (c *ConfigImpl) GetCardPoolMaxSize() int {
+	return c.CardPoolMaxSize
+}
+
+func (c *ConfigImpl) GetAgentThoughts() string {
+	return c.AgentThoughts
+}
+
 func (c *ConfigImpl) IsInitialized() bool {
 	return c.Initialized
 }
@@ -7,6 +7,7 @@ import (
 	"start-feishubot/services"
 	"start-feishubot/services/cardcreator"
 	"start-feishubot/services/cardpool"
+	"start-feishubot/services/cardtemplate"
 	"start-feishubot/services/core"
 	"start-feishubot/services/feishu"
 	"time"
@@ -17,8 +18,25 @@ var (
 	cardCreator  core.CardCreator
 	msgCache     core.MessageCache
 	cardPool     *cardpool.CardPool
+	botInfo      *feishu.BotInfo
+	resources    core.ResourceDownloader
+	msgReader    core.MessageReader
 )
 
+// Card modes
+const (
+	CardModeStreaming = "streaming"
+	CardModeMessage   = "message"
+)
+
+// getCardMode returns the card mode, streaming by default
+func getCardMode(mode string) string {
+	if mode == CardModeMessage {
+		return CardModeMessage
+	}
+	return CardModeStreaming
+}
+
 // NewMessageCache creates a new message cache
 func NewMessageCache() core.MessageCache {
 	return core.NewMessageCache()
@@ -32,9 +50,34 @@ func NewSessionCache() core.SessionCache {
 // createCardAdapter adapts CardCreator.CreateCardEntity to cardpool.CreateCardFn
 func createCardAdapter(creator core.CardCreator) func(context.Context) (string, error) {
 	return func(ctx context.Context) (string, error) {
-		// Use empty content for pool cards
-		return creator.CreateCardEntity(ctx, "")
+		// 池中的卡片预先渲染为等待状态
+		return creator.CreateCardEntity(ctx, cardtemplate.Pending(""))
+	}
+}
+
+// Bot info fetch settings
+const (
+	BotInfoAttempts      = 3
+	BotInfoRetryInterval = 2 * time.Second
+)
+
+// fetchBotInfo fetches the bot identity, retrying temporary failures
+func fetchBotInfo(feishuConfig *feishu.ConfigAdapter) (*feishu.BotInfo, error) {
+	var err error
+	for attempt := 1; attempt <= BotInfoAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		var info *feishu.BotInfo
+		info, err = feishu.GetBotInfo(ctx, feishuConfig.GetLarkClient())
+		cancel()
+		if err == nil {
+			return info, nil
+		}
+		log.Printf("[Services] Failed to get bot info (attempt %d/%d): %v", attempt, BotInfoAttempts, err)
+		if attempt < BotInfoAttempts {
+			time.Sleep(BotInfoRetryInterval)
+		}
 	}
+	return nil, err
 }
 
 // InitializeServices initializes all services
@@ -50,17 +93,46 @@ func InitializeServices() error {
 	feishuConfig := feishu.NewConfigAdapter(config)
 	log.Printf("[Services] Feishu config adapter initialized")
 
-	// Initialize card creator
-	cardCreator = cardcreator.NewCardCreator(feishuConfig)
-	log.Printf("[Services] Card creator initialized")
+	// Fetch bot identity for mention detection, without it group messages
+	// could not tell mentions of the bot from mentions of other members
+	info, err := fetchBotInfo(feishuConfig)
+	if err != nil {
+		return fmt.Errorf("failed to get bot info: %w", err)
+	}
+	botInfo = info
+	log.Printf("[Services] Bot info fetched: %s (%s)", botInfo.AppName, botInfo.OpenID)
 
-	// Initialize card pool with adapter
-	log.Printf("[Services] Starting card pool initialization")
-	if err := InitCardPool(createCardAdapter(cardCreator)); err != nil {
-		return fmt.Errorf("failed to initialize card pool: %w", err)
+	// Initialize card creator
+	cardMode := getCardMode(config.GetCardMode())
+	if cardMode == CardModeMessage {
+		cardCreator = cardcreator.NewCardCreator(feishuConfig)
+	} else {
+		cardCreator = cardcreator.NewStreamingCardCreator(feishuConfig)
+	}
+	log.Printf("[Services] Card creator initialized in %s mode", cardMode)
+
+	// Initialize message resource downloader
+	resources = feishu.NewResourceDownloader(feishuConfig)
+	log.Printf("[Services] Resource downloader initialized")
+
+	// Initialize message reader for quoted replies
+	msgReader = feishu.NewMessageReader(feishuConfig)
+	log.Printf("[Services] Message reader initialized")
+
+	// Initialize card pool with adapter. Pooled cards are CardKit entities not
+	// bound to any chat, message cards are created by the reply itself.
+	if cardMode == CardModeStreaming {
+		log.Printf("[Services] Starting card pool initialization")
+		poolConfig := cardpool.Config{
+			MinSize: config.GetCardPoolMinSize(),
+			MaxSize: config.GetCardPoolMaxSize(),
+		}
+		if err := InitCardPool(createCardAdapter(cardCreator), poolConfig); err != nil {
+			return fmt.Errorf("failed to initialize card pool: %w", err)
+		}
+		cardPool = cardPoolInstance
+		log.Printf("[Services] Card pool initialized with size: %d", cardPool.GetPoolSize())
 	}
-	cardPool = GetCardPool()
-	log.Printf("[Services] Card pool initialized with size: %d", cardPool.GetPoolSize())
 
 	// Initialize session cache
 	sessionCache = NewSessionCache()
@@ -90,7 +162,22 @@ func GetMsgCache() core.MessageCache {
 	return msgCache
 }
 
-// GetCardPool returns the card pool service
+// GetResourceDownloader returns the message resource downloader
+func GetResourceDownloader() core.ResourceDownloader {
+	return resources
+}
+
+// GetMessageReader returns the message reader
+func GetMessageReader() core.MessageReader {
+	return msgReader
+}
+
+// GetBotInfo returns the bot identity, nil before the services are initialized
+func GetBotInfo() *feishu.BotInfo {
+	return botInfo
+}
+
+// GetCardPool returns the card pool service, nil in message card mode
 func GetCardPool() *cardpool.CardPool {
 	return cardPool
 }
@@ -98,6 +185,8 @@ func GetCardPool() *cardpool.CardPool {
 // ShutdownServices performs cleanup of all services
 func ShutdownServices() {
 	if cardPool != nil {
-		cardPool.Stop()
+		ShutdownCardPool()
+		cardPool = nil
 	}
+	log.Printf("[Services] Services shut down")
 }
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/pflag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"start-feishubot/handlers"
 	"start-feishubot/initialization"
+	"syscall"
 	"time"
 )
 
+// ShutdownTimeout is how long in-flight requests and answers may take to
+// finish after a shutdown signal
+const ShutdownTimeout = 30 * time.Second
+
 func main() {
 	// Parse command line flags
 	pflag.Parse()
@@ -26,6 +35,10 @@ func main() {
 	}
 	log.Printf("[Main] Configuration loaded successfully")
 
+	// Load role list
+	initialization.InitRoleList()
+	log.Printf("[Main] Role list loaded")
+
 	// Set global config for handlers
 	log.Printf("[Main] Setting global config for handlers")
 	handlers.SetConfig(config)
@@ -46,24 +59,19 @@ func main() {
 	}
 	log.Printf("[Main] Handlers initialization completed in %v", time.Since(handlersStartTime))
 
-	// Register shutdown hook
-	defer func() {
-		handlers.Shutdown()
-	}()
-
 	// Set up Gin
 	r := gin.Default()
 
 	// Register routes
-	r.POST("/webhook/event", func(c *gin.Context) {
+	r.POST("/webhook/event", handlers.VerifyMiddleware(config, handlers.VerifyRequest), func(c *gin.Context) {
 		if err := handlers.Handler(c); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 	})
 
-	r.POST("/webhook/card", func(c *gin.Context) {
-		if err := handlers.Handler(c); err != nil {
+	r.POST("/webhook/card", handlers.VerifyMiddleware(config, handlers.VerifyCardCallback), func(c *gin.Context) {
+		if err := handlers.CardHandler(c); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -73,8 +81,32 @@ func main() {
 
 	// Start server
 	addr := fmt.Sprintf(":%s", config.GetHttpPort())
-	log.Printf("[Main] Server starting on %s", addr)
-	if err := r.Run(addr); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+	go func() {
+		log.Printf("[Main] Server starting on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for a shutdown signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	log.Printf("[Main] Received %v, shutting down", sig)
+
+	// Stop accepting callbacks, let running answers finish, then stop the services
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[Main] Failed to shut down server: %v", err)
+	}
+	if err := handlers.Shutdown(ctx); err != nil {
+		log.Printf("[Main] Answers still running at shutdown: %v", err)
 	}
+	initialization.ShutdownServices()
+	log.Printf("[Main] ===== Application stopped =====")
 }
@@ -0,0 +1,90 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode classifies AI errors
+type ErrorCode string
+
+// Error codes
+const (
+	ErrCodeUnknown              ErrorCode = ""
+	ErrCodeInvalidRequest       ErrorCode = "invalid_request"        // 请求参数错误，重试无效
+	ErrCodeUnauthorized         ErrorCode = "unauthorized"           // API密钥无效
+	ErrCodeConversationNotFound ErrorCode = "conversation_not_found" // 远端会话不存在
+	ErrCodeQuotaExceeded        ErrorCode = "quota_exceeded"         // 模型额度用尽
+	ErrCodeRateLimited          ErrorCode = "rate_limited"           // 请求过于频繁
+	ErrCodeUnavailable          ErrorCode = "unavailable"            // 服务或模型暂不可用
+	ErrCodeConnection           ErrorCode = "connection"             // 网络错误
+	ErrCodeStream               ErrorCode = "stream"                 // 流式响应中断或格式错误
+)
+
+// Sentinel errors to match with errors.Is, only the code is compared
+var (
+	ErrInvalidRequest       = &Error{Code: ErrCodeInvalidRequest, Message: "invalid request"}
+	ErrUnauthorized         = &Error{Code: ErrCodeUnauthorized, Message: "unauthorized"}
+	ErrConversationNotFound = &Error{Code: ErrCodeConversationNotFound, Message: "conversation not found"}
+	ErrQuotaExceeded        = &Error{Code: ErrCodeQuotaExceeded, Message: "quota exceeded"}
+	ErrRateLimited          = &Error{Code: ErrCodeRateLimited, Message: "rate limited"}
+	ErrUnavailable          = &Error{Code: ErrCodeUnavailable, Message: "service unavailable"}
+)
+
+// Error represents an AI error
+type Error struct {
+	Code    ErrorCode // 错误分类
+	Message string    // 错误说明
+	Status  int       // HTTP状态码，非HTTP错误为0
+	Err     error     // 原始错误
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Code != ErrCodeUnknown {
+		msg = fmt.Sprintf("%s: %s", e.Code, msg)
+	}
+	if e.Status != 0 {
+		msg = fmt.Sprintf("%s (status %d)", msg, e.Status)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an AI error with the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && e.Code != ErrCodeUnknown && e.Code == t.Code
+}
+
+// Temporary reports whether retrying the request may succeed
+func (e *Error) Temporary() bool {
+	switch e.Code {
+	case ErrCodeRateLimited, ErrCodeUnavailable, ErrCodeConnection, ErrCodeStream:
+		return true
+	}
+	return false
+}
+
+// NewError creates a new AI error
+func NewError(message string) error {
+	return &Error{Message: message}
+}
+
+// WrapError creates an AI error of the code caused by err
+func WrapError(code ErrorCode, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// IsTemporary reports whether err is an AI error worth retrying
+func IsTemporary(err error) bool {
+	var aiErr *Error
+	return errors.As(err, &aiErr) && aiErr.Temporary()
+}
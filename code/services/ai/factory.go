@@ -89,13 +89,13 @@ func (f *Factory) GetProvider() (Provider, error) {
 }
 
 // StreamChat streams chat messages using the configured provider
-func (f *Factory) StreamChat(ctx context.Context, messages []Message, responseStream chan string) error {
+func (f *Factory) StreamChat(ctx context.Context, messages []Message, events chan<- StreamEvent) error {
 	provider, err := f.GetProvider()
 	if err != nil {
 		return err
 	}
 
-	return provider.StreamChat(ctx, messages, responseStream)
+	return provider.StreamChat(ctx, messages, events)
 }
 
 // Close closes the factory and its provider
@@ -9,6 +9,29 @@ type Message struct {
 	Role      string                 `json:"role"`
 	Content   string                 `json:"content"`
 	Metadata  map[string]string      `json:"metadata,omitempty"`
+	Files     []File                 `json:"-"` // 随消息发送的附件，不保存在会话历史中
+}
+
+// File types
+const (
+	FileTypeImage    = "image"
+	FileTypeDocument = "document"
+	FileTypeAudio    = "audio"
+)
+
+// File represents a file attached to a message
+type File struct {
+	Type     string
+	Name     string
+	MimeType string
+	Data     []byte
+	UploadID string // 已上传到AI服务的文件ID，非空时不再上传Data
+}
+
+// FileUploader is implemented by providers that accept files uploaded ahead of a chat
+type FileUploader interface {
+	// UploadFile uploads a file for the user and returns its upload ID
+	UploadFile(ctx context.Context, userID string, file File) (string, error)
 }
 
 // Validate validates the message
@@ -16,7 +39,7 @@ func (m *Message) Validate() error {
 	if m.Role == "" {
 		return ErrEmptyRole
 	}
-	if m.Content == "" {
+	if m.Content == "" && len(m.Files) == 0 {
 		return ErrEmptyContent
 	}
 	return nil
@@ -24,29 +47,23 @@ func (m *Message) Validate() error {
 
 // Provider defines the interface for AI providers
 type Provider interface {
-	// StreamChat streams chat messages
-	StreamChat(ctx context.Context, messages []Message, responseStream chan string) error
+	// StreamChat streams the answer to the messages as events,
+	// ending with a FinalEvent when it succeeds
+	StreamChat(ctx context.Context, messages []Message, events chan<- StreamEvent) error
 	
 	// Close closes the provider and cleans up resources
 	Close() error
 }
 
+// Suggester is implemented by providers that suggest follow-up questions
+type Suggester interface {
+	// SuggestedQuestions returns the follow-up questions of an answered message,
+	// messageID comes from the FinalEvent of the answer
+	SuggestedQuestions(ctx context.Context, userID string, messageID string) ([]string, error)
+}
+
 // Common errors
 var (
 	ErrEmptyRole    = NewError("empty role")
 	ErrEmptyContent = NewError("empty content")
 )
-
-// Error represents an AI error
-type Error struct {
-	Message string
-}
-
-func (e *Error) Error() string {
-	return e.Message
-}
-
-// NewError creates a new AI error
-func NewError(message string) error {
-	return &Error{Message: message}
-}
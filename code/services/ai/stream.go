@@ -0,0 +1,136 @@
+package ai
+
+import (
+	"context"
+)
+
+// StreamEvent is an event of a streaming chat, one of DeltaEvent,
+// ReplaceEvent, ThoughtEvent, ToolCallEvent, FileEvent, UsageEvent,
+// CitationEvent and FinalEvent
+type StreamEvent interface {
+	streamEvent()
+}
+
+// DeltaEvent carries the next chunk of the answer text
+type DeltaEvent struct {
+	Text string
+}
+
+// ReplaceEvent replaces the whole answer text sent so far, e.g. when
+// content moderation rejects the answer
+type ReplaceEvent struct {
+	Text string
+}
+
+// ThoughtEvent carries a reasoning step of an agent.
+// A step is sent again with the same ID when it is updated.
+type ThoughtEvent struct {
+	ID       string
+	Position int // 步骤序号，从1开始
+	Thought  string
+}
+
+// ToolCallEvent carries a tool called by an agent.
+// The call is sent again with the same ID once the observation is known.
+type ToolCallEvent struct {
+	ID          string
+	Position    int
+	Tool        string
+	Input       string
+	Observation string // 工具返回结果，调用完成前为空
+}
+
+// FileEvent carries a file produced while answering
+type FileEvent struct {
+	ID        string
+	Type      string // FileTypeImage等文件类型
+	URL       string
+	BelongsTo string // user或assistant
+}
+
+// UsageEvent carries the token usage of the answer
+type UsageEvent struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	TotalPrice       string
+	Currency         string
+	Latency          float64 // 秒
+}
+
+// CitationEvent carries a knowledge base segment the answer is based on
+type CitationEvent struct {
+	Position     int
+	DatasetID    string
+	DatasetName  string
+	DocumentID   string
+	DocumentName string
+	SegmentID    string
+	Content      string
+	Score        float64
+	URL          string // 文档来源链接，没有时为空
+}
+
+// FinalEvent is the last event of a successful stream
+type FinalEvent struct {
+	MessageID      string
+	ConversationID string
+}
+
+func (DeltaEvent) streamEvent()    {}
+func (ReplaceEvent) streamEvent()  {}
+func (ThoughtEvent) streamEvent()  {}
+func (ToolCallEvent) streamEvent() {}
+func (FileEvent) streamEvent()     {}
+func (UsageEvent) streamEvent()    {}
+func (CitationEvent) streamEvent() {}
+func (FinalEvent) streamEvent()    {}
+
+// SendEvent sends the event unless ctx is done first
+func SendEvent(ctx context.Context, events chan<- StreamEvent, event StreamEvent) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case events <- event:
+		return nil
+	}
+}
+
+// StreamText streams the chat for consumers that only need the answer text.
+// Only the text of delta events is sent on responseStream.
+func StreamText(ctx context.Context, provider Provider, messages []Message, responseStream chan string) error {
+	return ForwardText(ctx, func(events chan<- StreamEvent) error {
+		return provider.StreamChat(ctx, messages, events)
+	}, responseStream)
+}
+
+// ForwardText runs stream and forwards the text of its delta events to
+// responseStream, other events are dropped
+func ForwardText(ctx context.Context, stream func(events chan<- StreamEvent) error, responseStream chan string) error {
+	events := make(chan StreamEvent)
+	streamDone := make(chan error, 1)
+	go func() {
+		streamDone <- stream(events)
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			delta, ok := event.(DeltaEvent)
+			if !ok || delta.Text == "" {
+				continue
+			}
+			select {
+			case responseStream <- delta.Text:
+			case <-ctx.Done():
+				// 等待stream因上下文取消而退出
+				if err := <-streamDone; err != nil {
+					return err
+				}
+				return ctx.Err()
+			}
+		case err := <-streamDone:
+			return err
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeProvider replays events and then returns err
+type fakeProvider struct {
+	events []StreamEvent
+	err    error
+}
+
+func (p *fakeProvider) StreamChat(ctx context.Context, messages []Message, events chan<- StreamEvent) error {
+	for _, event := range p.events {
+		if err := SendEvent(ctx, events, event); err != nil {
+			return err
+		}
+	}
+	return p.err
+}
+
+func (p *fakeProvider) Close() error {
+	return nil
+}
+
+func TestStreamText(t *testing.T) {
+	errStream := errors.New("stream failed")
+	tests := []struct {
+		name    string
+		events  []StreamEvent
+		err     error
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "only delta text is forwarded",
+			events: []StreamEvent{
+				ThoughtEvent{ID: "1", Thought: "thinking"},
+				DeltaEvent{Text: "Hello"},
+				ToolCallEvent{ID: "1", Tool: "search"},
+				DeltaEvent{},
+				DeltaEvent{Text: " world"},
+				CitationEvent{Position: 1},
+				FinalEvent{MessageID: "m1"},
+			},
+			want: []string{"Hello", " world"},
+		},
+		{
+			name:    "stream error",
+			events:  []StreamEvent{DeltaEvent{Text: "Hel"}},
+			err:     errStream,
+			want:    []string{"Hel"},
+			wantErr: errStream,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responseStream := make(chan string)
+			done := make(chan error, 1)
+			provider := &fakeProvider{events: tt.events, err: tt.err}
+			go func() { done <- StreamText(context.Background(), provider, nil, responseStream) }()
+
+			var got []string
+			for {
+				select {
+				case text := <-responseStream:
+					got = append(got, text)
+					continue
+				case err := <-done:
+					if !errors.Is(err, tt.wantErr) {
+						t.Errorf("StreamText() error = %v, want %v", err, tt.wantErr)
+					}
+				}
+				break
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StreamText() text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamTextStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	provider := &fakeProvider{events: []StreamEvent{DeltaEvent{Text: "a"}, DeltaEvent{Text: "b"}}}
+	done := make(chan error, 1)
+	// 没有读取方时，取消上下文后应立即返回
+	go func() { done <- StreamText(ctx, provider, nil, make(chan string)) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("StreamText() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("StreamText() did not return after cancellation")
+	}
+}
@@ -2,7 +2,9 @@ package cardcreator
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 	"start-feishubot/services/feishu"
@@ -21,87 +23,122 @@ func NewCardCreator(config *feishu.ConfigAdapter) *CardCreator {
 	}
 }
 
-// CreateCardEntity implements core.CardCreator interface
+// ErrCardEntityUnsupported is returned when creating a card ahead of time in message mode
+var ErrCardEntityUnsupported = errors.New("message cards can only be created by replying to a message")
+
+// CreateCardEntity implements core.CardCreator interface.
+// Message cards belong to the chat they are sent to, so they can't be created
+// ahead of time; use ReplyCard instead.
 func (c *CardCreator) CreateCardEntity(ctx context.Context, content string) (string, error) {
-	log.Printf("[CardCreator] Starting card entity creation at %v", time.Now().Format("15:04:05"))
+	return "", ErrCardEntityUnsupported
+}
+
+// UpdateCardContent updates the content of an existing card message.
+// Plain text content is wrapped in a markdown card.
+func (c *CardCreator) UpdateCardContent(ctx context.Context, cardID string, content string) (string, error) {
+	log.Printf("[CardCreator] Starting card content update at %v", time.Now().Format("15:04:05"))
 	startTime := time.Now()
 
-	// Use Feishu API to create card entity
+	if !isCardJSON(content) {
+		cardJSON, err := newMessageCardJSON(content)
+		if err != nil {
+			return "", err
+		}
+		content = cardJSON
+	}
+
+	// Use Feishu API to patch the card message
 	client := c.config.GetLarkClient()
-	req := larkim.NewCreateMessageReqBuilder().
-		ReceiveIdType("chat_id").
-		Body(larkim.NewCreateMessageReqBodyBuilder().
-			MsgType("interactive").
+	req := larkim.NewPatchMessageReqBuilder().
+		MessageId(cardID).
+		Body(larkim.NewPatchMessageReqBodyBuilder().
 			Content(content).
 			Build()).
 		Build()
 
-	log.Printf("[CardCreator] Creating card entity with URL: https://open.feishu.cn/open-apis/cardkit/v1/cards/ at %v", time.Now().Format("15:04:05"))
+	log.Printf("[CardCreator] Updating card content with URL: https://open.feishu.cn/open-apis/im/v1/messages/%s at %v", cardID, time.Now().Format("15:04:05"))
 
-	// Record token fetch time
-	tokenTime := time.Since(startTime)
-	log.Printf("[CardCreator] Token fetch took: %d ms at %v", tokenTime.Milliseconds(), time.Now().Format("15:04:05"))
-
-	resp, err := client.Im.Message.Create(ctx, req)
+	resp, err := client.Im.Message.Patch(ctx, req)
 	if err != nil {
 		return "", err
 	}
-
-	// Record API request time
-	apiTime := time.Since(startTime) - tokenTime
-	log.Printf("[CardCreator] Card entity API request took: %d ms at %v", apiTime.Milliseconds(), time.Now().Format("15:04:05"))
-
-	if resp.Data == nil || resp.Data.MessageId == nil {
-		return "", errors.New("failed to get message ID from response")
+	if !resp.Success() {
+		return "", fmt.Errorf("failed to update card: [%d] %s", resp.Code, resp.Msg)
 	}
 
-	log.Printf("[CardCreator] Successfully created card entity with ID: %s at %v", *resp.Data.MessageId, time.Now().Format("15:04:05"))
-
 	// Record total time
 	totalTime := time.Since(startTime)
-	log.Printf("[CardCreator] Total card entity creation took: %d ms at %v", totalTime.Milliseconds(), time.Now().Format("15:04:05"))
+	log.Printf("[CardCreator] Total card content update took: %d ms at %v", totalTime.Milliseconds(), time.Now().Format("15:04:05"))
 
-	return *resp.Data.MessageId, nil
+	return cardID, nil
 }
 
-// UpdateCardContent updates the content of an existing card
-func (c *CardCreator) UpdateCardContent(ctx context.Context, cardID string, content string) (string, error) {
-	log.Printf("[CardCreator] Starting card content update at %v", time.Now().Format("15:04:05"))
+// FinishCardContent writes the final content of a card
+func (c *CardCreator) FinishCardContent(ctx context.Context, cardID string, content string) error {
+	_, err := c.UpdateCardContent(ctx, cardID, content)
+	return err
+}
+
+// newMessageCardJSON builds a message card with a single markdown element
+func newMessageCardJSON(content string) (string, error) {
+	card := map[string]interface{}{
+		"config": map[string]interface{}{
+			"wide_screen_mode": true,
+			"update_multi":     true,
+		},
+		"elements": []interface{}{
+			map[string]interface{}{
+				"tag":     "markdown",
+				"content": content,
+			},
+		},
+	}
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ReplyCardEntity replies to a message with a CardKit card entity and returns the new message ID
+func (c *CardCreator) ReplyCardEntity(ctx context.Context, messageID string, cardID string) (string, error) {
+	content, err := json.Marshal(map[string]interface{}{
+		"type": "card",
+		"data": map[string]interface{}{
+			"card_id": cardID,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return c.ReplyCard(ctx, messageID, string(content))
+}
+
+// ReplyCard replies to a message with an interactive card and returns the new message ID
+func (c *CardCreator) ReplyCard(ctx context.Context, messageID string, content string) (string, error) {
+	log.Printf("[CardCreator] Replying card to message %s at %v", messageID, time.Now().Format("15:04:05"))
 	startTime := time.Now()
 
-	// Use Feishu API to update card content
 	client := c.config.GetLarkClient()
-	req := larkim.NewUpdateMessageReqBuilder().
-		MessageId(cardID).
-		Body(larkim.NewUpdateMessageReqBodyBuilder().
+	req := larkim.NewReplyMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewReplyMessageReqBodyBuilder().
+			MsgType("interactive").
 			Content(content).
 			Build()).
 		Build()
 
-	log.Printf("[CardCreator] Updating card content with URL: https://open.feishu.cn/open-apis/cardkit/v1/cards/%s at %v", cardID, time.Now().Format("15:04:05"))
-
-	// Record token fetch time
-	tokenTime := time.Since(startTime)
-	log.Printf("[CardCreator] Token fetch took: %d ms at %v", tokenTime.Milliseconds(), time.Now().Format("15:04:05"))
-
-	resp, err := client.Im.Message.Update(ctx, req)
+	resp, err := client.Im.Message.Reply(ctx, req)
 	if err != nil {
 		return "", err
 	}
-
-	// Record API request time
-	apiTime := time.Since(startTime) - tokenTime
-	log.Printf("[CardCreator] Card content update API request took: %d ms at %v", apiTime.Milliseconds(), time.Now().Format("15:04:05"))
-
+	if !resp.Success() {
+		return "", fmt.Errorf("failed to reply card: [%d] %s", resp.Code, resp.Msg)
+	}
 	if resp.Data == nil || resp.Data.MessageId == nil {
 		return "", errors.New("failed to get message ID from response")
 	}
 
-	log.Printf("[CardCreator] Successfully updated card content with ID: %s at %v", *resp.Data.MessageId, time.Now().Format("15:04:05"))
-
-	// Record total time
-	totalTime := time.Since(startTime)
-	log.Printf("[CardCreator] Total card content update took: %d ms at %v", totalTime.Milliseconds(), time.Now().Format("15:04:05"))
-
+	log.Printf("[CardCreator] Card reply took: %d ms, message ID: %s", time.Since(startTime).Milliseconds(), *resp.Data.MessageId)
 	return *resp.Data.MessageId, nil
 }
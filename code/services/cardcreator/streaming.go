@@ -0,0 +1,298 @@
+package cardcreator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	"log"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/services/feishu"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StreamingElementID is the ID of the markdown element updated while streaming
+const StreamingElementID = cardtemplate.BodyElementID
+
+// SequenceTTL is how long the operation sequence of an idle card is kept.
+// Finished cards may still be updated, e.g. with suggested questions, so
+// their sequences are kept until they have been idle for this long.
+const SequenceTTL = 24 * time.Hour
+
+// StreamingCardCreator implements core.CardCreator with CardKit card entities.
+// Cards are created in streaming mode and their text element is updated
+// incrementally, which renders as a typewriter effect without flickering.
+// Message cards sent with ReplyCard are updated as messages.
+type StreamingCardCreator struct {
+	*CardCreator
+	sequences sync.Map // cardID -> *cardSequence, CardKit要求同一卡片的操作序号严格递增
+	layouts   sync.Map // cardID -> string, 最近一次整卡更新的卡片结构（正文置空）
+}
+
+// NewStreamingCardCreator creates a new streaming card creator instance
+func NewStreamingCardCreator(config *feishu.ConfigAdapter) *StreamingCardCreator {
+	return &StreamingCardCreator{
+		CardCreator: NewCardCreator(config),
+	}
+}
+
+// cardSequence is the last operation sequence of a card
+type cardSequence struct {
+	value    int64
+	lastUsed int64 // UnixNano
+}
+
+// cardKitResp is the common response of CardKit APIs
+type cardKitResp struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// CreateCardEntity implements core.CardCreator interface.
+// content is either a card JSON or the initial text of the streaming element.
+func (c *StreamingCardCreator) CreateCardEntity(ctx context.Context, content string) (string, error) {
+	startTime := time.Now()
+
+	cardJSON := content
+	if !isCardJSON(content) {
+		var err error
+		if cardJSON, err = newStreamingCardJSON(content); err != nil {
+			return "", err
+		}
+	}
+
+	var data struct {
+		CardID string `json:"card_id"`
+	}
+	err := c.callCardKit(ctx, "POST", "/open-apis/cardkit/v1/cards", map[string]interface{}{
+		"type": "card_json",
+		"data": cardJSON,
+	}, &data)
+	if err != nil {
+		return "", err
+	}
+	if data.CardID == "" {
+		return "", fmt.Errorf("failed to get card ID from response")
+	}
+
+	log.Printf("[CardCreator] Created streaming card %s in %d ms", data.CardID, time.Since(startTime).Milliseconds())
+	return data.CardID, nil
+}
+
+// UpdateCardContent implements core.CardCreator interface.
+// content is either a card JSON replacing the whole card, or the full text
+// of the streaming element, of which CardKit renders the appended part
+// incrementally. A streaming card JSON that differs from the last one only
+// in the text of the streaming element is sent as that text.
+func (c *StreamingCardCreator) UpdateCardContent(ctx context.Context, cardID string, content string) (string, error) {
+	if isMessageID(cardID) {
+		// 卡片池不可用时通过ReplyCard回复的消息卡片，按消息更新
+		return c.CardCreator.UpdateCardContent(ctx, cardID, content)
+	}
+	startTime := time.Now()
+	if isCardJSON(content) {
+		// 卡片结构未变时只推送正文，保留打字机效果并避免整卡重绘
+		layout, text, streaming := splitStreamingText(content)
+		if last, ok := c.layouts.Load(cardID); !streaming || !ok || last.(string) != layout {
+			c.layouts.Delete(cardID)
+			if err := c.updateCardEntity(ctx, cardID, content); err != nil {
+				return "", err
+			}
+			if streaming {
+				c.layouts.Store(cardID, layout)
+			}
+			log.Printf("[CardCreator] Updated card %s in %d ms", cardID, time.Since(startTime).Milliseconds())
+			return cardID, nil
+		}
+		content = text
+	}
+
+	path := fmt.Sprintf("/open-apis/cardkit/v1/cards/%s/elements/%s/content", cardID, StreamingElementID)
+	err := c.callCardKit(ctx, "PUT", path, map[string]interface{}{
+		"content":  content,
+		"sequence": c.nextSequence(cardID),
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[CardCreator] Streamed %d bytes to card %s in %d ms", len(content), cardID, time.Since(startTime).Milliseconds())
+	return cardID, nil
+}
+
+// FinishCardContent implements core.CardCreator interface.
+// It writes the final content and closes the streaming mode of the card.
+// A final card JSON is expected to close the streaming mode by itself.
+func (c *StreamingCardCreator) FinishCardContent(ctx context.Context, cardID string, content string) error {
+	if isMessageID(cardID) {
+		return c.CardCreator.FinishCardContent(ctx, cardID, content)
+	}
+	defer c.layouts.Delete(cardID)
+	defer c.sweepSequences()
+
+	if _, err := c.UpdateCardContent(ctx, cardID, content); err != nil {
+		return err
+	}
+	if isCardJSON(content) {
+		return nil
+	}
+
+	settings, err := json.Marshal(map[string]interface{}{
+		"config": map[string]interface{}{
+			"streaming_mode": false,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/open-apis/cardkit/v1/cards/%s/settings", cardID)
+	if err := c.callCardKit(ctx, "PATCH", path, map[string]interface{}{
+		"settings": string(settings),
+		"sequence": c.nextSequence(cardID),
+	}, nil); err != nil {
+		return err
+	}
+
+	log.Printf("[CardCreator] Closed streaming of card %s", cardID)
+	return nil
+}
+
+// isMessageID reports whether the ID is a message ID rather than a card entity ID
+func isMessageID(id string) bool {
+	return strings.HasPrefix(id, "om_")
+}
+
+// updateCardEntity replaces the whole card entity with a card JSON
+func (c *StreamingCardCreator) updateCardEntity(ctx context.Context, cardID string, cardJSON string) error {
+	path := fmt.Sprintf("/open-apis/cardkit/v1/cards/%s", cardID)
+	return c.callCardKit(ctx, "PUT", path, map[string]interface{}{
+		"card": map[string]interface{}{
+			"type": "card_json",
+			"data": cardJSON,
+		},
+		"sequence": c.nextSequence(cardID),
+	}, nil)
+}
+
+// nextSequence returns the next operation sequence of the card
+func (c *StreamingCardCreator) nextSequence(cardID string) int64 {
+	v, _ := c.sequences.LoadOrStore(cardID, &cardSequence{})
+	seq := v.(*cardSequence)
+	atomic.StoreInt64(&seq.lastUsed, time.Now().UnixNano())
+	return atomic.AddInt64(&seq.value, 1)
+}
+
+// sweepSequences drops the sequences of cards idle for longer than SequenceTTL
+func (c *StreamingCardCreator) sweepSequences() {
+	expired := time.Now().Add(-SequenceTTL).UnixNano()
+	c.sequences.Range(func(key, v interface{}) bool {
+		if atomic.LoadInt64(&v.(*cardSequence).lastUsed) < expired {
+			c.sequences.Delete(key)
+		}
+		return true
+	})
+}
+
+// callCardKit calls a CardKit API and decodes its data into out
+func (c *StreamingCardCreator) callCardKit(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	client := c.config.GetLarkClient()
+
+	var resp *larkcore.ApiResp
+	var err error
+	switch method {
+	case "POST":
+		resp, err = client.Post(ctx, path, body, larkcore.AccessTokenTypeTenant)
+	case "PUT":
+		resp, err = client.Put(ctx, path, body, larkcore.AccessTokenTypeTenant)
+	case "PATCH":
+		resp, err = client.Patch(ctx, path, body, larkcore.AccessTokenTypeTenant)
+	default:
+		return fmt.Errorf("unsupported method %s", method)
+	}
+	if err != nil {
+		return err
+	}
+
+	var result cardKitResp
+	if err := json.Unmarshal(resp.RawBody, &result); err != nil {
+		return fmt.Errorf("failed to parse %s %s response: %v", method, path, err)
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("%s %s failed: [%d] %s", method, path, result.Code, result.Msg)
+	}
+	if out != nil && len(result.Data) > 0 {
+		return json.Unmarshal(result.Data, out)
+	}
+	return nil
+}
+
+// newStreamingCardJSON builds a card JSON 2.0 in streaming mode with a single markdown element
+func newStreamingCardJSON(content string) (string, error) {
+	card := map[string]interface{}{
+		"schema": "2.0",
+		"config": map[string]interface{}{
+			"streaming_mode": true,
+			"update_multi":   true,
+			"summary": map[string]interface{}{
+				"content": "[生成中...]",
+			},
+		},
+		"body": map[string]interface{}{
+			"elements": []interface{}{
+				map[string]interface{}{
+					"tag":        "markdown",
+					"element_id": StreamingElementID,
+					"content":    content,
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// splitStreamingText splits a card JSON in streaming mode into its layout,
+// the card with the streaming element emptied, and the text of that element
+func splitStreamingText(cardJSON string) (string, string, bool) {
+	var card map[string]interface{}
+	if err := json.Unmarshal([]byte(cardJSON), &card); err != nil {
+		return "", "", false
+	}
+	config, _ := card["config"].(map[string]interface{})
+	if streaming, _ := config["streaming_mode"].(bool); !streaming {
+		return "", "", false
+	}
+
+	body, _ := card["body"].(map[string]interface{})
+	elements, _ := body["elements"].([]interface{})
+	for _, e := range elements {
+		element, _ := e.(map[string]interface{})
+		if element["element_id"] != StreamingElementID {
+			continue
+		}
+		text, ok := element["content"].(string)
+		if !ok {
+			return "", "", false
+		}
+		element["content"] = ""
+		layout, err := json.Marshal(card)
+		if err != nil {
+			return "", "", false
+		}
+		return string(layout), text, true
+	}
+	return "", "", false
+}
+
+// isCardJSON reports whether the content is already a card JSON
+func isCardJSON(content string) bool {
+	content = strings.TrimSpace(content)
+	return strings.HasPrefix(content, "{") && json.Valid([]byte(content))
+}
@@ -0,0 +1,233 @@
+package cardcreator
+
+import (
+	"context"
+	"encoding/json"
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"start-feishubot/services/cardtemplate"
+	"start-feishubot/services/config"
+	"start-feishubot/services/feishu"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cardKitCall is a CardKit request received by the fake server
+type cardKitCall struct {
+	Method   string
+	Path     string
+	Content  string // 流式更新的正文
+	Sequence int64
+}
+
+// fakeCardKit is a local CardKit server recording the card operations
+type fakeCardKit struct {
+	mu    sync.Mutex
+	calls []cardKitCall
+}
+
+func (f *fakeCardKit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if strings.Contains(r.URL.Path, "tenant_access_token") {
+		w.Write([]byte(`{"code":0,"msg":"ok","tenant_access_token":"t-test","expire":7200}`))
+		return
+	}
+
+	var body struct {
+		Content  string `json:"content"`
+		Sequence int64  `json:"sequence"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.calls = append(f.calls, cardKitCall{Method: r.Method, Path: r.URL.Path, Content: body.Content, Sequence: body.Sequence})
+	f.mu.Unlock()
+	w.Write([]byte(`{"code":0,"msg":"success","data":{"card_id":"card_1"}}`))
+}
+
+// takeCalls returns the calls received since the last take
+func (f *fakeCardKit) takeCalls() []cardKitCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func newTestStreamingCardCreator(t *testing.T) (*fakeCardKit, *StreamingCardCreator) {
+	f := &fakeCardKit{}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	adapter := feishu.NewConfigAdapter(&config.ConfigImpl{
+		FeishuAppID:     "cli_test",
+		FeishuAppSecret: "secret",
+	}, lark.WithOpenBaseUrl(server.URL))
+	return f, NewStreamingCardCreator(adapter)
+}
+
+func TestCreateCardEntity(t *testing.T) {
+	f, c := newTestStreamingCardCreator(t)
+
+	cardID, err := c.CreateCardEntity(context.Background(), "思考中...")
+	if err != nil {
+		t.Fatalf("CreateCardEntity() error = %v", err)
+	}
+	if cardID != "card_1" {
+		t.Errorf("CreateCardEntity() = %s, want card_1", cardID)
+	}
+	got := f.takeCalls()
+	if len(got) != 1 || got[0].Method != "POST" || got[0].Path != "/open-apis/cardkit/v1/cards" {
+		t.Errorf("calls = %+v, want one card creation", got)
+	}
+}
+
+func TestStreamingCardSequence(t *testing.T) {
+	f, c := newTestStreamingCardCreator(t)
+	ctx := context.Background()
+	cardPath := "/open-apis/cardkit/v1/cards/card_1"
+	elementPath := cardPath + "/elements/" + StreamingElementID + "/content"
+
+	for _, content := range []string{"你好", "你好，世界"} {
+		if _, err := c.UpdateCardContent(ctx, "card_1", content); err != nil {
+			t.Fatalf("UpdateCardContent() error = %v", err)
+		}
+	}
+	if err := c.FinishCardContent(ctx, "card_1", "你好，世界！"); err != nil {
+		t.Fatalf("FinishCardContent() error = %v", err)
+	}
+	// 结束后再次更新，序号继续递增
+	if _, err := c.UpdateCardContent(ctx, "card_1", "再见"); err != nil {
+		t.Fatalf("UpdateCardContent() error = %v", err)
+	}
+
+	want := []cardKitCall{
+		{Method: "PUT", Path: elementPath, Content: "你好", Sequence: 1},
+		{Method: "PUT", Path: elementPath, Content: "你好，世界", Sequence: 2},
+		{Method: "PUT", Path: elementPath, Content: "你好，世界！", Sequence: 3},
+		{Method: "PATCH", Path: cardPath + "/settings", Sequence: 4},
+		{Method: "PUT", Path: elementPath, Content: "再见", Sequence: 5},
+	}
+	got := f.takeCalls()
+	if len(got) != len(want) {
+		t.Fatalf("got %d calls %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("call %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestUpdateCardContentStreamsBodyText(t *testing.T) {
+	f, c := newTestStreamingCardCreator(t)
+	ctx := context.Background()
+	cardPath := "/open-apis/cardkit/v1/cards/card_1"
+	elementPath := cardPath + "/elements/" + StreamingElementID + "/content"
+
+	updates := []string{
+		cardtemplate.Pending(""),
+		cardtemplate.Streaming("你好"),
+		cardtemplate.Streaming("你好，世界"),
+		cardtemplate.StreamingAnswer(cardtemplate.Answer{
+			Body:  "你好，世界",
+			Steps: []cardtemplate.AgentStep{{Thought: "查询天气"}},
+		}),
+		cardtemplate.StreamingAnswer(cardtemplate.Answer{
+			Body:  "你好，世界！",
+			Steps: []cardtemplate.AgentStep{{Thought: "查询天气"}},
+		}),
+	}
+	for _, content := range updates {
+		if _, err := c.UpdateCardContent(ctx, "card_1", content); err != nil {
+			t.Fatalf("UpdateCardContent() error = %v", err)
+		}
+	}
+	if err := c.FinishCardContent(ctx, "card_1", cardtemplate.Final("你好，世界！", time.Second)); err != nil {
+		t.Fatalf("FinishCardContent() error = %v", err)
+	}
+
+	want := []cardKitCall{
+		{Method: "PUT", Path: cardPath, Sequence: 1},
+		{Method: "PUT", Path: elementPath, Content: "你好", Sequence: 2},
+		{Method: "PUT", Path: elementPath, Content: "你好，世界", Sequence: 3},
+		{Method: "PUT", Path: cardPath, Sequence: 4}, // 步骤面板出现，更新整张卡片
+		{Method: "PUT", Path: elementPath, Content: "你好，世界！", Sequence: 5},
+		{Method: "PUT", Path: cardPath, Sequence: 6}, // 最终卡片
+	}
+	got := f.takeCalls()
+	if len(got) != len(want) {
+		t.Fatalf("got %d calls %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("call %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFinishCardContentTwice(t *testing.T) {
+	f, c := newTestStreamingCardCreator(t)
+	ctx := context.Background()
+
+	if _, err := c.UpdateCardContent(ctx, "card_1", cardtemplate.Streaming("你好")); err != nil {
+		t.Fatalf("UpdateCardContent() error = %v", err)
+	}
+	// 回答结束后再次写入最终卡片，例如追加建议问题
+	for i := 0; i < 2; i++ {
+		if err := c.FinishCardContent(ctx, "card_1", cardtemplate.Final("你好", time.Second)); err != nil {
+			t.Fatalf("FinishCardContent() #%d error = %v", i+1, err)
+		}
+	}
+
+	var sequences []int64
+	for _, call := range f.takeCalls() {
+		sequences = append(sequences, call.Sequence)
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(sequences, want) {
+		t.Errorf("sequences = %v, want %v", sequences, want)
+	}
+}
+
+func TestUpdateMessageCard(t *testing.T) {
+	f, c := newTestStreamingCardCreator(t)
+	ctx := context.Background()
+
+	// 卡片池不可用时回复的消息卡片按消息更新
+	if _, err := c.UpdateCardContent(ctx, "om_1", cardtemplate.Streaming("你好")); err != nil {
+		t.Fatalf("UpdateCardContent() error = %v", err)
+	}
+	if err := c.FinishCardContent(ctx, "om_1", cardtemplate.Final("你好", time.Second)); err != nil {
+		t.Fatalf("FinishCardContent() error = %v", err)
+	}
+
+	got := f.takeCalls()
+	if len(got) != 2 {
+		t.Fatalf("got %d calls %+v, want 2", len(got), got)
+	}
+	for i, call := range got {
+		if call.Method != "PATCH" || call.Path != "/open-apis/im/v1/messages/om_1" {
+			t.Errorf("call %d = %s %s, want PATCH of the message", i, call.Method, call.Path)
+		}
+	}
+}
+
+func TestIsCardJSON(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{content: `{"schema":"2.0"}`, want: true},
+		{content: " {\"elements\":[]}\n", want: true},
+		{content: "你好", want: false},
+		{content: "{未完成的回答", want: false},
+	}
+	for _, tt := range tests {
+		if got := isCardJSON(tt.content); got != tt.want {
+			t.Errorf("isCardJSON(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
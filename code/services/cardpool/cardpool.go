@@ -1,8 +1,8 @@
 package cardpool
 
 import (
-	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -10,10 +10,23 @@ import (
 )
 
 const (
-	PoolSize        = 20              // 卡片池大小
-	CardExpiration  = 24 * time.Hour  // 卡片过期时间
-	MaxRetries      = 3               // 最大重试次数
-	RetryInterval   = 1 * time.Second // 重试间隔
+	DefaultMinSize     = 5                // 卡片池最小容量
+	DefaultMaxSize     = 20               // 卡片池最大容量
+	DefaultConcurrency = 3                // 后台同时创建卡片的最大数量
+	CardExpiration     = 24 * time.Hour   // 卡片过期时间
+	DemandWindow       = 10 * time.Minute // 统计近期取卡需求的时间窗口
+	MaintainInterval   = 30 * time.Second // 后台维护间隔
+	BackoffBase        = 1 * time.Second  // 创建失败后的初始退避时间
+	BackoffMax         = 1 * time.Minute  // 最大退避时间
+	BreakerThreshold   = 5                // 连续失败多少次后熔断
+	BreakerCooldown    = 1 * time.Minute  // 熔断持续时间
+)
+
+var (
+	// ErrCircuitOpen is returned when card creation keeps failing and is paused
+	ErrCircuitOpen = errors.New("card creation circuit breaker is open")
+	// ErrPoolStopped is returned when getting a card after Stop
+	ErrPoolStopped = errors.New("card pool stopped")
 )
 
 // CardEntry 表示卡片池中的一个卡片条目
@@ -22,223 +35,399 @@ type CardEntry struct {
 	CreatedAt time.Time // 创建时间
 }
 
+// Config 卡片池配置，零值使用默认值
+type Config struct {
+	MinSize     int           // 最小容量
+	MaxSize     int           // 最大容量
+	Concurrency int           // 后台同时创建卡片的最大数量
+	Expiration  time.Duration // 卡片过期时间
+}
+
+// withDefaults fills zero fields with the default values
+func (c Config) withDefaults() Config {
+	if c.MinSize <= 0 {
+		c.MinSize = DefaultMinSize
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultMaxSize
+	}
+	if c.MaxSize < c.MinSize {
+		c.MaxSize = c.MinSize
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.Expiration <= 0 {
+		c.Expiration = CardExpiration
+	}
+	return c
+}
+
+// Stats 卡片池统计
+type Stats struct {
+	Size              int           // 当前卡片数
+	Target            int           // 按近期需求计算的目标容量
+	Hits              uint64        // 从池中直接取到卡片的次数
+	Misses            uint64        // 池为空需要同步创建的次数
+	Evictions         uint64        // 过期淘汰的卡片数
+	Created           uint64        // 创建成功的卡片数
+	CreateFailures    uint64        // 创建失败次数
+	AvgCreateLatency  time.Duration // 平均创建耗时
+	LastCreateLatency time.Duration // 最近一次创建耗时
+	BreakerOpen       bool          // 是否处于熔断中
+}
+
 // CardPool 卡片池结构
+// 池中是预先创建、不属于任何会话的CardKit卡片实体，取出后按card_id回复到提问的会话中。
+// 卡片由后台补充协程生产、GetCard消费，经由带缓冲的channel传递，网络请求期间不持有锁。
 type CardPool struct {
-	cards     *list.List    // 卡片链表
-	mu        sync.RWMutex  // 保护cards的并发访问
-	createFn  CreateCardFn  // 创建卡片的函数
-	stopChan  chan struct{} // 用于停止后台任务
-	isRunning bool         // 标记后台任务是否运行中
+	cards    chan *CardEntry // 可用卡片，按创建时间排序
+	createFn CreateCardFn    // 创建卡片的函数
+	config   Config          // 卡片池配置
+	refill   chan struct{}   // 通知后台补充卡片
+	now      func() time.Time
+
+	// 后台任务使用卡片池自己的上下文，不受调用方取消的影响
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+
+	mu        sync.Mutex    // 保护以下字段，只用于统计和熔断状态
+	demand    []time.Time   // 时间窗口内的取卡时间
+	stats     Stats         // 统计数据
+	latency   time.Duration // 累计创建耗时
+	failures  int           // 连续创建失败次数
+	openUntil time.Time     // 熔断结束时间
 }
 
-// CreateCardFn 定义创建卡片的函数类型
+// CreateCardFn 定义创建卡片的函数类型，返回卡片实体ID
 type CreateCardFn func(context.Context) (string, error)
 
-// NewCardPool creates and initializes a new card pool
+// NewCardPool creates and initializes a new card pool with the default config
 func NewCardPool(createFn CreateCardFn) *CardPool {
-	p := &CardPool{}
-	p.Init(createFn)
+	return NewCardPoolWithConfig(createFn, Config{})
+}
+
+// NewCardPoolWithConfig creates and initializes a new card pool
+func NewCardPoolWithConfig(createFn CreateCardFn, config Config) *CardPool {
+	p := newCardPool(createFn, config)
+	p.Init()
 	return p
 }
 
-// Init 初始化卡片池
-func (p *CardPool) Init(createFn CreateCardFn) {
-	log.Printf("[CardPool] Initializing card pool with target size: %d", PoolSize)
-	p.cards = list.New()
-	p.createFn = createFn
-	p.stopChan = make(chan struct{})
+func newCardPool(createFn CreateCardFn, config Config) *CardPool {
+	config = config.withDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CardPool{
+		cards:    make(chan *CardEntry, config.MaxSize),
+		createFn: createFn,
+		config:   config,
+		refill:   make(chan struct{}, 1),
+		now:      time.Now,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
 
-	// 同步初始化卡片池
-	log.Printf("[CardPool] ===== Starting initial pool fill with size %d at %v =====", PoolSize, time.Now().Format("15:04:05"))
+// Init 同步填充到最小容量并启动后台补充协程
+func (p *CardPool) Init() {
+	log.Printf("[CardPool] ===== Starting initial pool fill, size %d-%d at %v =====",
+		p.config.MinSize, p.config.MaxSize, time.Now().Format("15:04:05"))
 	startTime := time.Now()
-	p.fillPool(context.Background())
-	log.Printf("[CardPool] ===== Initial pool fill completed at %v, took %v, current size: %d =====", 
-		time.Now().Format("15:04:05"),
-		time.Since(startTime),
-		p.GetPoolSize())
+	p.fillPool(p.ctx)
+	log.Printf("[CardPool] ===== Initial pool fill completed, took %v, current size: %d =====",
+		time.Since(startTime), p.GetPoolSize())
 
-	// 启动后台任务
-	p.startBackgroundTasks()
+	p.start()
 }
 
-// startBackgroundTasks 启动后台任务
-func (p *CardPool) startBackgroundTasks() {
-	p.mu.Lock()
-	if p.isRunning {
-		p.mu.Unlock()
-		return
-	}
-	p.isRunning = true
-	p.mu.Unlock()
-
-	// 启动定时重建任务
-	go p.rebuildAtMidnight()
+// start 启动后台补充协程
+func (p *CardPool) start() {
+	p.wg.Add(1)
+	go p.maintain()
 }
 
-// Stop 停止卡片池的后台任务
+// Stop 停止后台任务，取消进行中的创建请求并等待其退出
 func (p *CardPool) Stop() {
-	p.mu.Lock()
-	if !p.isRunning {
-		p.mu.Unlock()
-		return
-	}
-	p.isRunning = false
-	close(p.stopChan)
-	p.mu.Unlock()
+	p.stopOnce.Do(p.cancel)
+	p.wg.Wait()
 }
 
-// rebuildAtMidnight 在每天0点重建卡片池
-func (p *CardPool) rebuildAtMidnight() {
+// maintain 定期淘汰过期卡片，并按需求补充卡片
+func (p *CardPool) maintain() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(MaintainInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-p.stopChan:
+		case <-p.ctx.Done():
 			return
-		default:
-			now := time.Now()
-			next := now.Add(24 * time.Hour)
-			next = time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, next.Location())
-			duration := next.Sub(now)
-
-			select {
-			case <-p.stopChan:
-				return
-			case <-time.After(duration):
-				log.Printf("Starting daily card pool rebuild at %v", time.Now())
-				p.RebuildPool(context.Background())
-			}
+		case <-ticker.C:
+			p.evictExpired()
+		case <-p.refill:
 		}
+		p.fillPool(p.ctx)
+	}
+}
+
+// triggerRefill 通知后台补充卡片，不阻塞
+func (p *CardPool) triggerRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
 	}
 }
 
 // RebuildPool 重建整个卡片池
 func (p *CardPool) RebuildPool(ctx context.Context) {
-	p.mu.Lock()
-	p.cards = list.New() // 清空现有卡片
-	p.mu.Unlock()
-
-	// 重新填充池
-	log.Printf("Rebuilding pool with size %d", PoolSize)
+	p.drain(func(*CardEntry) bool { return false })
+	log.Printf("[CardPool] Rebuilding pool")
 	p.fillPool(ctx)
 }
 
-// fillPool 填充卡片池至目标大小
+// fillPool 并发填充卡片池至目标容量，失败时指数退避，熔断或取消时放弃本轮填充
 func (p *CardPool) fillPool(ctx context.Context) {
-	for {
-		p.mu.RLock()
-		currentSize := p.cards.Len()
-		p.mu.RUnlock()
+	for ctx.Err() == nil {
+		deficit := p.target() - len(p.cards)
+		if deficit <= 0 {
+			return
+		}
+		if deficit > p.config.Concurrency {
+			deficit = p.config.Concurrency
+		}
 
-		if currentSize >= PoolSize {
-			log.Printf("[CardPool] Pool filled to target size: %d at %v", PoolSize, time.Now().Format("15:04:05"))
-			break
+		var wg sync.WaitGroup
+		errs := make(chan error, deficit)
+		for i := 0; i < deficit; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cardID, err := p.createCard(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				p.put(cardID)
+			}()
 		}
+		wg.Wait()
+		close(errs)
 
-		cardStartTime := time.Now()
-		log.Printf("[CardPool] >>>>> Creating card %d/%d at %v", currentSize+1, PoolSize, time.Now().Format("15:04:05"))
-		
-		// 同步创建新卡片
-		if err := p.CreateCardWithRetry(ctx); err != nil {
-			log.Printf("[CardPool] !!!!! Failed to create card %d/%d: %v", currentSize+1, PoolSize, err)
-			// 继续尝试创建，避免池子逐渐缩小
+		var lastErr error
+		for err := range errs {
+			if errors.Is(err, ErrCircuitOpen) {
+				log.Printf("[CardPool] Card creation paused by circuit breaker, pool size: %d", len(p.cards))
+				return
+			}
+			lastErr = err
+		}
+		if lastErr == nil {
 			continue
 		}
-		
-		log.Printf("[CardPool] <<<<< Card %d/%d created successfully in %v", currentSize+1, PoolSize, time.Since(cardStartTime))
 
-		// 避免创建过快
-		time.Sleep(100 * time.Millisecond)
+		delay := p.backoff()
+		log.Printf("[CardPool] Failed to create cards, retrying in %v: %v", delay, lastErr)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// put 将卡片放入池中，池满时丢弃
+func (p *CardPool) put(cardID string) {
+	select {
+	case p.cards <- &CardEntry{CardID: cardID, CreatedAt: p.now()}:
+	default:
+		log.Printf("[CardPool] Pool is full, dropping card %s", cardID)
+	}
+}
+
+// drain 取出当前所有卡片，keep返回true的卡片放回池中
+func (p *CardPool) drain(keep func(*CardEntry) bool) {
+	for n := len(p.cards); n > 0; n-- {
+		select {
+		case card := <-p.cards:
+			if !keep(card) {
+				continue
+			}
+			select {
+			case p.cards <- card:
+			default:
+			}
+		default:
+			return
+		}
 	}
 }
 
-// CreateCardWithRetry 创建卡片并进行重试
-func (p *CardPool) CreateCardWithRetry(ctx context.Context) error {
-	var cardID string
-	var err error
-	
-	// 重试逻辑
-	for i := 0; i < MaxRetries; i++ {
-		if i > 0 {
-			// 重试前等待
-			time.Sleep(RetryInterval)
+// evictExpired 淘汰过期卡片
+func (p *CardPool) evictExpired() {
+	p.drain(func(card *CardEntry) bool {
+		if p.expired(card) {
+			p.recordEviction()
+			return false
 		}
+		return true
+	})
+}
 
-		log.Printf("[CardPool] Attempting to create card (attempt %d/%d) at %v", i+1, MaxRetries, time.Now().Format("15:04:05"))
-		cardID, err = p.createFn(ctx)
-		if err == nil {
-			log.Printf("[CardPool] Successfully created card with ID: %s", cardID)
-			break
+// take 取出第一张未过期的卡片，池为空时返回nil
+func (p *CardPool) take() *CardEntry {
+	for {
+		select {
+		case card := <-p.cards:
+			if !p.expired(card) {
+				return card
+			}
+			p.recordEviction()
+		default:
+			return nil
 		}
-		log.Printf("[CardPool] Failed to create card (attempt %d/%d): %v", i+1, MaxRetries, err)
 	}
+}
+
+func (p *CardPool) expired(card *CardEntry) bool {
+	return card.CreatedAt.Before(p.now().Add(-p.config.Expiration))
+}
 
+func (p *CardPool) recordEviction() {
+	p.mu.Lock()
+	p.stats.Evictions++
+	p.mu.Unlock()
+}
+
+// createCard 创建一张卡片并记录耗时和失败次数，熔断期间直接返回ErrCircuitOpen
+func (p *CardPool) createCard(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	if p.now().Before(p.openUntil) {
+		p.mu.Unlock()
+		return "", ErrCircuitOpen
+	}
+	p.mu.Unlock()
+
+	startTime := time.Now()
+	cardID, err := p.createFn(ctx)
+	elapsed := time.Since(startTime)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if err != nil {
-		return fmt.Errorf("failed to create card after %d attempts: %w", MaxRetries, err)
+		// 调用方取消不计入失败
+		if ctx.Err() != nil {
+			return "", err
+		}
+		p.stats.CreateFailures++
+		p.failures++
+		// 熔断结束后的试探仍然失败时立即重新熔断
+		if p.failures >= BreakerThreshold {
+			p.openUntil = p.now().Add(BreakerCooldown)
+			log.Printf("[CardPool] %d consecutive creation failures, pausing creation for %v", p.failures, BreakerCooldown)
+		}
+		return "", err
 	}
 
-	// 将新卡片添加到池中
+	p.failures = 0
+	p.stats.Created++
+	p.stats.LastCreateLatency = elapsed
+	p.latency += elapsed
+	log.Printf("[CardPool] Created card %s in %v", cardID, elapsed)
+	return cardID, nil
+}
+
+// backoff returns the delay before retrying after consecutive failures
+func (p *CardPool) backoff() time.Duration {
 	p.mu.Lock()
-	p.cards.PushBack(&CardEntry{
-		CardID:    cardID,
-		CreatedAt: time.Now(),
-	})
+	failures := p.failures
 	p.mu.Unlock()
 
-	log.Printf("[CardPool] Successfully created and added new card to pool: %s at %v", cardID, time.Now().Format("15:04:05"))
-	return nil
+	delay := BackoffBase
+	for i := 1; i < failures && delay < BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > BackoffMax {
+		delay = BackoffMax
+	}
+	return delay
 }
 
-// GetCard 从池中获取一个卡片
-func (p *CardPool) GetCard(ctx context.Context) (string, error) {
+// target 根据时间窗口内的取卡次数计算目标容量
+func (p *CardPool) target() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.targetLocked()
+}
 
-	// 检查是否有可用卡片
-	if p.cards.Len() == 0 {
-		log.Printf("[CardPool] No cards available in pool, creating new one at %v", time.Now().Format("15:04:05"))
-		// 如果没有可用卡片，使用CreateCardWithRetry创建一个
-		if err := p.CreateCardWithRetry(ctx); err != nil {
-			return "", fmt.Errorf("failed to create card: %w", err)
+// targetLocked 同target，调用方需持有锁
+func (p *CardPool) targetLocked() int {
+	since := p.now().Add(-DemandWindow)
+	recent := p.demand[:0]
+	for _, t := range p.demand {
+		if t.After(since) {
+			recent = append(recent, t)
 		}
+	}
+	p.demand = recent
 
-		// 获取刚创建的卡片
-		element := p.cards.Back()
-		p.cards.Remove(element)
-		card := element.Value.(*CardEntry)
-
-		// 异步创建一个新卡片补充到池中
-		go func() {
-			if err := p.CreateCardWithRetry(ctx); err != nil {
-				log.Printf("[CardPool] Failed to create replacement card at %v: %v", time.Now().Format("15:04:05"), err)
-				// 继续尝试创建，避免池子逐渐缩小
-				go p.CreateCardWithRetry(ctx)
-			}
-		}()
-
-		return card.CardID, nil
+	target := len(p.demand)
+	if target < p.config.MinSize {
+		target = p.config.MinSize
+	}
+	if target > p.config.MaxSize {
+		target = p.config.MaxSize
 	}
+	return target
+}
 
-	// 获取并移除第一个卡片
-	element := p.cards.Front()
-	p.cards.Remove(element)
-	card := element.Value.(*CardEntry)
+// GetCard 从池中获取一个未过期的卡片，池为空时使用调用方的上下文同步创建
+func (p *CardPool) GetCard(ctx context.Context) (string, error) {
+	if p.ctx.Err() != nil {
+		return "", ErrPoolStopped
+	}
+	p.mu.Lock()
+	p.demand = append(p.demand, p.now())
+	p.mu.Unlock()
+	defer p.triggerRefill()
 
-	log.Printf("[CardPool] Got card from pool: %s, remaining cards: %d at %v", card.CardID, p.cards.Len(), time.Now().Format("15:04:05"))
+	if card := p.take(); card != nil {
+		p.mu.Lock()
+		p.stats.Hits++
+		p.mu.Unlock()
+		log.Printf("[CardPool] Got card from pool: %s, remaining cards: %d", card.CardID, len(p.cards))
+		return card.CardID, nil
+	}
 
-	// 异步创建新卡片补充到池中
-	go func() {
-		if err := p.CreateCardWithRetry(ctx); err != nil {
-			log.Printf("Failed to create replacement card: %v", err)
-			// 继续尝试创建，避免池子逐渐缩小
-			go p.CreateCardWithRetry(ctx)
-		}
-	}()
+	p.mu.Lock()
+	p.stats.Misses++
+	p.mu.Unlock()
 
-	return card.CardID, nil
+	log.Printf("[CardPool] No cards available in pool, creating new one")
+	cardID, err := p.createCard(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create card: %w", err)
+	}
+	return cardID, nil
 }
 
 // GetPoolSize 获取当前池中的卡片数量
 func (p *CardPool) GetPoolSize() int {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-	return p.cards.Len()
+	return len(p.cards)
+}
+
+// Stats returns a snapshot of the pool statistics
+func (p *CardPool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Size = len(p.cards)
+	stats.Target = p.targetLocked()
+	stats.BreakerOpen = p.now().Before(p.openUntil)
+	if stats.Created > 0 {
+		stats.AvgCreateLatency = p.latency / time.Duration(stats.Created)
+	}
+	return stats
 }
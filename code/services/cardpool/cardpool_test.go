@@ -0,0 +1,323 @@
+package cardpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for the pool
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestPool creates a pool without background tasks
+func newTestPool(createFn CreateCardFn, config Config) (*CardPool, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p := newCardPool(createFn, config)
+	p.now = clock.Now
+	return p, clock
+}
+
+func counterCreateFn() CreateCardFn {
+	var mu sync.Mutex
+	n := 0
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return fmt.Sprintf("card-%d", n), nil
+	}
+}
+
+func TestGetCardEvictsExpired(t *testing.T) {
+	p, clock := newTestPool(counterCreateFn(), Config{MinSize: 2, MaxSize: 2, Expiration: time.Hour})
+	p.fillPool(context.Background())
+
+	clock.Advance(2 * time.Hour)
+	cardID, err := p.GetCard(context.Background())
+	if err != nil {
+		t.Fatalf("GetCard() error = %v", err)
+	}
+	if cardID != "card-3" {
+		t.Errorf("GetCard() = %s, want a newly created card-3", cardID)
+	}
+
+	stats := p.Stats()
+	if stats.Evictions != 2 || stats.Misses != 1 || stats.Hits != 0 {
+		t.Errorf("Stats() = %+v, want 2 evictions and 1 miss", stats)
+	}
+}
+
+func TestTargetFollowsDemand(t *testing.T) {
+	tests := []struct {
+		name   string
+		gets   int
+		elapse time.Duration
+		want   int
+	}{
+		{name: "no demand uses min size", gets: 0, want: 3},
+		{name: "demand within bounds", gets: 5, want: 5},
+		{name: "demand capped at max size", gets: 20, want: 8},
+		{name: "old demand is forgotten", gets: 20, elapse: DemandWindow + time.Second, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, clock := newTestPool(counterCreateFn(), Config{MinSize: 3, MaxSize: 8})
+			for i := 0; i < tt.gets; i++ {
+				if _, err := p.GetCard(context.Background()); err != nil {
+					t.Fatalf("GetCard() error = %v", err)
+				}
+			}
+			clock.Advance(tt.elapse)
+			if got := p.Stats().Target; got != tt.want {
+				t.Errorf("Stats().Target = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOpensAfterFailures(t *testing.T) {
+	fail := true
+	calls := 0
+	p, clock := newTestPool(func(ctx context.Context) (string, error) {
+		calls++
+		if fail {
+			return "", errors.New("rate limited")
+		}
+		return "card", nil
+	}, Config{})
+
+	for i := 0; i < BreakerThreshold; i++ {
+		if _, err := p.createCard(context.Background()); err == nil {
+			t.Fatalf("createCard() attempt %d succeeded, want error", i+1)
+		}
+	}
+	if _, err := p.GetCard(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("GetCard() error = %v, want ErrCircuitOpen", err)
+	}
+	if calls != BreakerThreshold {
+		t.Errorf("createFn called %d times, want %d", calls, BreakerThreshold)
+	}
+	if !p.Stats().BreakerOpen {
+		t.Errorf("Stats().BreakerOpen = false, want true")
+	}
+
+	// 熔断结束后恢复创建
+	fail = false
+	clock.Advance(BreakerCooldown)
+	if _, err := p.GetCard(context.Background()); err != nil {
+		t.Fatalf("GetCard() after cooldown error = %v", err)
+	}
+	stats := p.Stats()
+	if stats.BreakerOpen || stats.CreateFailures != BreakerThreshold || stats.Created != 1 {
+		t.Errorf("Stats() = %+v, want closed breaker, %d failures and 1 created", stats, BreakerThreshold)
+	}
+}
+
+func TestBackoffGrowsToMax(t *testing.T) {
+	p, _ := newTestPool(counterCreateFn(), Config{})
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: BackoffBase},
+		{failures: 1, want: BackoffBase},
+		{failures: 3, want: 4 * BackoffBase},
+		{failures: 20, want: BackoffMax},
+	}
+	for _, tt := range tests {
+		p.failures = tt.failures
+		if got := p.backoff(); got != tt.want {
+			t.Errorf("backoff() with %d failures = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %v", timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConcurrentGetCardOnEmptyPool(t *testing.T) {
+	createFn := counterCreateFn()
+	p, _ := newTestPool(func(ctx context.Context) (string, error) {
+		time.Sleep(5 * time.Millisecond)
+		return createFn(ctx)
+	}, Config{})
+
+	const callers = 50
+	ids := make(chan string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cardID, err := p.GetCard(context.Background())
+			if err != nil {
+				t.Errorf("GetCard() error = %v", err)
+				return
+			}
+			ids <- cardID
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetCard() deadlocked on an empty pool")
+	}
+	close(ids)
+
+	seen := make(map[string]bool)
+	for cardID := range ids {
+		if seen[cardID] {
+			t.Errorf("card %s handed out twice", cardID)
+		}
+		seen[cardID] = true
+	}
+	if stats := p.Stats(); stats.Misses != callers {
+		t.Errorf("Stats().Misses = %d, want %d", stats.Misses, callers)
+	}
+}
+
+func TestRefillBoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	createFn := counterCreateFn()
+	p := NewCardPoolWithConfig(func(ctx context.Context) (string, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond)
+		return createFn(ctx)
+	}, Config{MinSize: 10, MaxSize: 10, Concurrency: 2})
+	defer p.Stop()
+
+	if size := p.GetPoolSize(); size != 10 {
+		t.Errorf("GetPoolSize() = %d after Init, want 10", size)
+	}
+
+	// 并发取卡后由后台补充协程补齐
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.GetCard(context.Background()); err != nil {
+				t.Errorf("GetCard() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, 5*time.Second, func() bool { return p.GetPoolSize() == 10 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight > 2 {
+		t.Errorf("max concurrent creations = %d, want at most 2", maxInFlight)
+	}
+}
+
+func TestRefillIgnoresCallerCancellation(t *testing.T) {
+	var mu sync.Mutex
+	cancelledCalls := 0
+	createFn := counterCreateFn()
+	p := NewCardPoolWithConfig(func(ctx context.Context) (string, error) {
+		if ctx.Err() != nil {
+			mu.Lock()
+			cancelledCalls++
+			mu.Unlock()
+			return "", ctx.Err()
+		}
+		return createFn(ctx)
+	}, Config{MinSize: 2, MaxSize: 2})
+	defer p.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := p.GetCard(ctx); err != nil {
+			t.Fatalf("GetCard() error = %v", err)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool { return p.GetPoolSize() == 2 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if cancelledCalls != 0 {
+		t.Errorf("refill used the caller's cancelled context %d times", cancelledCalls)
+	}
+}
+
+func TestStopCancelsRefill(t *testing.T) {
+	started := make(chan struct{}, 1)
+	p, _ := newTestPool(func(ctx context.Context) (string, error) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return "", ctx.Err()
+	}, Config{MinSize: 1, MaxSize: 1})
+	p.start()
+	p.triggerRefill()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refill did not start")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop() did not return")
+	}
+
+	if _, err := p.GetCard(context.Background()); !errors.Is(err, ErrPoolStopped) {
+		t.Errorf("GetCard() after Stop error = %v, want ErrPoolStopped", err)
+	}
+	p.Stop() // 重复调用不应阻塞或panic
+}
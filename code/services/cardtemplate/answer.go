@@ -0,0 +1,177 @@
+package cardtemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BodyElementID is the ID of the markdown element holding the answer.
+// Streaming cards update it element-wise for the typewriter effect.
+const BodyElementID = "streaming_content"
+
+// Answer card texts
+const (
+	AnswerTitle        = "🤖 AI 回复"
+	ThinkingText       = "思考中…"
+	StreamingSummary   = "[生成中...]"
+	EmptyAnswerText    = "（没有生成回复内容）"
+	ErrorTitle         = "🤖 机器人提醒"
+	QuotaTitle         = "⚠️ 额度已用完"
+	DefaultQuotaText   = "AI 服务的调用额度已用完，请稍后再试或联系管理员"
+	DefaultPendingText = "正在处理..."
+)
+
+// Answer is the content of an answer card
+type Answer struct {
+	Body        string
+	Steps       []AgentStep  // 智能体的推理步骤，为空时不显示
+	ExpandSteps bool         // 推理步骤面板默认展开
+	Citations   []Citation   // 知识库引用，仅在最终卡片中显示
+	Suggestions []Suggestion // 建议的后续问题，仅在最终卡片中显示
+}
+
+// Pending returns the card shown before the answer starts, status is shown
+// as the body, e.g. "正在处理..."
+func Pending(status string) string {
+	if status == "" {
+		status = DefaultPendingText
+	}
+	return Streaming(status)
+}
+
+// Streaming returns the card of an answer being generated, with a thinking
+// indicator below the body
+func Streaming(body string) string {
+	return StreamingAnswer(Answer{Body: body})
+}
+
+// StreamingAnswer is Streaming with the agent steps above the body
+func StreamingAnswer(answer Answer) string {
+	elements := answerElements(answer)
+	elements = append(elements, map[string]interface{}{
+		"tag":       "markdown",
+		"content":   fmt.Sprintf("<font color='grey'>%s</font>", ThinkingText),
+		"text_size": "notation",
+	})
+
+	header := newHeader(AnswerTitle, "blue")
+	header["subtitle"] = plainText(ThinkingText)
+	return cardJSON(map[string]interface{}{
+		"update_multi":   true,
+		"streaming_mode": true,
+		"summary": map[string]interface{}{
+			"content": StreamingSummary,
+		},
+	}, header, elements)
+}
+
+// Final returns the card of a finished answer with the elapsed time in the footer
+func Final(body string, elapsed time.Duration) string {
+	return FinalAnswer(Answer{Body: body}, elapsed)
+}
+
+// FinalAnswer is Final with the agent steps above the body, the citations
+// as numbered footnotes and the suggested questions as buttons below it
+func FinalAnswer(answer Answer, elapsed time.Duration) string {
+	if strings.TrimSpace(answer.Body) == "" {
+		answer.Body = EmptyAnswerText
+	}
+	elements := answerElements(answer)
+	if len(answer.Citations) > 0 {
+		elements = append(elements, map[string]interface{}{"tag": "hr"}, citationsElement(answer.Citations))
+	}
+	if len(answer.Suggestions) > 0 {
+		elements = append(elements, suggestionElements(answer.Suggestions)...)
+	}
+	elements = append(elements,
+		map[string]interface{}{"tag": "hr"},
+		map[string]interface{}{
+			"tag":       "markdown",
+			"content":   fmt.Sprintf("⏱ 耗时 %s", FormatElapsed(elapsed)),
+			"text_size": "notation",
+		})
+	return cardJSON(finalConfig(), newHeader(AnswerTitle, "green"), elements)
+}
+
+// Error returns the card replacing an answer that failed
+func Error(message string) string {
+	return cardJSON(finalConfig(), newHeader(ErrorTitle, "red"), bodyElements(message))
+}
+
+// QuotaExceeded returns the card replacing an answer refused because the AI
+// quota is used up; message overrides the default explanation
+func QuotaExceeded(message string) string {
+	if message == "" {
+		message = DefaultQuotaText
+	}
+	return cardJSON(finalConfig(), newHeader(QuotaTitle, "orange"), bodyElements(message))
+}
+
+// FormatElapsed formats a duration as seconds with one decimal, e.g. "3.2s"
+func FormatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// answerElements returns the agent steps panel followed by the body
+func answerElements(answer Answer) []interface{} {
+	elements := []interface{}{}
+	if len(answer.Steps) > 0 {
+		elements = append(elements, stepsPanel(answer.Steps, answer.ExpandSteps))
+	}
+	return append(elements, bodyElements(answer.Body)...)
+}
+
+// bodyElements returns the answer body element, none for empty content
+func bodyElements(body string) []interface{} {
+	if body == "" {
+		return []interface{}{}
+	}
+	return []interface{}{
+		map[string]interface{}{
+			"tag":        "markdown",
+			"element_id": BodyElementID,
+			"content":    body,
+		},
+	}
+}
+
+func finalConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"update_multi":   true,
+		"streaming_mode": false,
+	}
+}
+
+func newHeader(title string, template string) map[string]interface{} {
+	return map[string]interface{}{
+		"template": template,
+		"title":    plainText(title),
+	}
+}
+
+func plainText(content string) map[string]interface{} {
+	return map[string]interface{}{
+		"tag":     "plain_text",
+		"content": content,
+	}
+}
+
+// cardJSON assembles a card JSON 2.0, the only schema CardKit card entities accept
+func cardJSON(config map[string]interface{}, header map[string]interface{}, elements []interface{}) string {
+	card := map[string]interface{}{
+		"schema": "2.0",
+		"config": config,
+		"header": header,
+		"body": map[string]interface{}{
+			"elements": elements,
+		},
+	}
+	// 卡片只包含基本类型、切片和嵌套map，序列化不会失败
+	data, _ := json.Marshal(card)
+	return string(data)
+}
@@ -0,0 +1,91 @@
+package cardtemplate
+
+import (
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+// ButtonBuilder builds a button whose action carries a CardMsg
+type ButtonBuilder struct {
+	text       string
+	msg        CardMsg
+	buttonType larkcard.MessageCardButtonType
+	confirm    *larkcard.MessageCardActionConfirm
+}
+
+// NewButton creates a default button sending msg when clicked
+func NewButton(text string, msg CardMsg) *ButtonBuilder {
+	return &ButtonBuilder{
+		text:       text,
+		msg:        msg,
+		buttonType: larkcard.MessageCardButtonTypeDefault,
+	}
+}
+
+// Primary marks the button as the primary action
+func (b *ButtonBuilder) Primary() *ButtonBuilder {
+	b.buttonType = larkcard.MessageCardButtonTypePrimary
+	return b
+}
+
+// Danger marks the button as a destructive action
+func (b *ButtonBuilder) Danger() *ButtonBuilder {
+	b.buttonType = larkcard.MessageCardButtonTypeDanger
+	return b
+}
+
+// Confirm asks the user to confirm before the action is sent
+func (b *ButtonBuilder) Confirm(title string, text string) *ButtonBuilder {
+	b.confirm = larkcard.NewMessageCardActionConfirm().
+		Title(larkcard.NewMessageCardPlainText().Content(title).Build()).
+		Text(larkcard.NewMessageCardPlainText().Content(text).Build()).
+		Build()
+	return b
+}
+
+// Build returns the card button
+func (b *ButtonBuilder) Build() *larkcard.MessageCardEmbedButton {
+	button := larkcard.NewMessageCardEmbedButton().
+		Type(b.buttonType).
+		Text(larkcard.NewMessageCardPlainText().Content(b.text).Build()).
+		Value(b.msg.ActionValue())
+	if b.confirm != nil {
+		button.Confirm(b.confirm)
+	}
+	return button.Build()
+}
+
+// SelectBuilder builds a static select menu whose action carries a CardMsg.
+// The selected option is sent back as the action option.
+type SelectBuilder struct {
+	placeholder string
+	msg         CardMsg
+	options     []string
+}
+
+// NewSelect creates a select menu with one option per value
+func NewSelect(placeholder string, msg CardMsg, options []string) *SelectBuilder {
+	return &SelectBuilder{
+		placeholder: placeholder,
+		msg:         msg,
+		options:     options,
+	}
+}
+
+// Build returns the card select menu
+func (b *SelectBuilder) Build() *larkcard.MessageCardEmbedSelectMenuStatic {
+	options := make([]*larkcard.MessageCardEmbedSelectOption, 0, len(b.options))
+	for _, option := range b.options {
+		options = append(options, larkcard.NewMessageCardEmbedSelectOption().
+			Text(larkcard.NewMessageCardPlainText().Content(option).Build()).
+			Value(option).
+			Build())
+	}
+
+	return larkcard.NewMessageCardEmbedSelectMenuStatic().
+		MessageCardEmbedSelectMenuStatic(larkcard.NewMessageCardEmbedSelectMenuBase().
+			Placeholder(larkcard.NewMessageCardPlainText().Content(b.placeholder).Build()).
+			Options(options).
+			Value(b.msg.ActionValue()).
+			Build()).
+		Build()
+}
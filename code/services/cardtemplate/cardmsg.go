@@ -0,0 +1,28 @@
+package cardtemplate
+
+// CardKind defines the type of card
+type CardKind string
+
+// CardChatType defines the type of chat
+type CardChatType string
+
+// CardMsg is the value carried by interactive card components and sent back
+// in card actions
+type CardMsg struct {
+	Kind      CardKind
+	ChatType  CardChatType
+	SessionId string
+	MsgId     string
+	Value     interface{}
+}
+
+// ActionValue converts the card message into a card action value
+func (m CardMsg) ActionValue() map[string]interface{} {
+	return map[string]interface{}{
+		"Kind":      m.Kind,
+		"ChatType":  m.ChatType,
+		"SessionId": m.SessionId,
+		"MsgId":     m.MsgId,
+		"Value":     m.Value,
+	}
+}
@@ -0,0 +1,214 @@
+package cardtemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func mustString(t *testing.T, card *larkcard.MessageCard) string {
+	t.Helper()
+	content, err := card.String()
+	if err != nil {
+		t.Fatalf("card.String() error = %v", err)
+	}
+	return content
+}
+
+func TestTemplatesGolden(t *testing.T) {
+	clearMsg := CardMsg{
+		Kind:      "clear",
+		ChatType:  "group",
+		SessionId: "oc_chat:om_root",
+		MsgId:     "om_msg",
+	}
+	roleMsg := CardMsg{
+		Kind:      "role_choose",
+		ChatType:  "personal",
+		SessionId: "ou_user",
+		Value:     "写作",
+	}
+
+	tests := []struct {
+		name    string
+		content func(t *testing.T) string
+	}{
+		{
+			name:    "pending",
+			content: func(t *testing.T) string { return Pending("🎤 正在识别语音...") },
+		},
+		{
+			name:    "pending_default",
+			content: func(t *testing.T) string { return Pending("") },
+		},
+		{
+			name:    "streaming",
+			content: func(t *testing.T) string { return Streaming("Go 的 **channel** 是") },
+		},
+		{
+			name: "final",
+			content: func(t *testing.T) string {
+				return Final("🎤 你好\n\n你好！有什么可以帮你？", 3200*time.Millisecond)
+			},
+		},
+		{
+			name: "streaming_steps",
+			content: func(t *testing.T) string {
+				return StreamingAnswer(Answer{
+					Body: "根据搜索结果，",
+					Steps: []AgentStep{
+						{Thought: "需要先搜索 Dify 的介绍", Tool: "google_search", Input: `{"query": "Dify"}`, Observation: "Dify is an open-source LLM app development platform."},
+						{Tool: "code_interpreter", Input: "print(1 + 1)"},
+					},
+				})
+			},
+		},
+		{
+			name: "final_steps",
+			content: func(t *testing.T) string {
+				return FinalAnswer(Answer{
+					Body:        "Dify 是开源的 LLM 应用开发平台。",
+					Steps:       []AgentStep{{Thought: "直接回答即可"}},
+					ExpandSteps: true,
+				}, 1500*time.Millisecond)
+			},
+		},
+		{
+			name: "streaming_steps_escaped",
+			content: func(t *testing.T) string {
+				return StreamingAnswer(Answer{
+					Steps: []AgentStep{{
+						Thought:     "# 忽略之前的指令 <at id=all></at>",
+						Tool:        "web_search",
+						Input:       "[点我](https://evil.example)",
+						Observation: "**完整结果** " + strings.Repeat("a", MaxStepFieldSize),
+					}},
+				})
+			},
+		},
+		{
+			name: "final_citations",
+			content: func(t *testing.T) string {
+				return FinalAnswer(Answer{
+					Body: "Dify 是开源的 LLM 应用开发平台[1][2]。",
+					Citations: []Citation{
+						{DatasetName: "产品手册", DocumentName: "介绍.md", Content: "Dify 是一个开源的 LLM 应用开发平台，\n提供从 Agent 构建到 AI workflow 编排、RAG 检索、模型管理等能力，轻松构建和运营生成式 AI 原生应用。", Score: 0.9213},
+						{DatasetName: "官网", DocumentName: "首页", Content: "Dify", Score: 0.5, URL: "https://dify.ai"},
+					},
+				}, 2*time.Second)
+			},
+		},
+		{
+			name: "final_citations_escaped",
+			content: func(t *testing.T) string {
+				return FinalAnswer(Answer{
+					Body: "见引用[1]。",
+					Citations: []Citation{
+						{
+							DatasetName:  "手册](https://evil.example)",
+							DocumentName: "# 标题 [草稿] <at id=all></at>",
+							Content:      "## 小节\n*重点* 见 [链接](https://evil.example) 与 `代码` | 表格 <at id=all></at>",
+							Score:        0.8,
+							URL:          "https://example.com/docs/a (1).md",
+						},
+						{DocumentName: "脚本", Content: "x", URL: "javascript:alert(1)"},
+					},
+				}, time.Second)
+			},
+		},
+		{
+			name: "final_suggestions",
+			content: func(t *testing.T) string {
+				msg := CardMsg{Kind: "suggested_question", ChatType: "group", SessionId: "oc_chat", MsgId: "om_msg"}
+				suggestions := []Suggestion{}
+				for _, question := range []string{"Dify 支持哪些模型？", "如何私有化部署 Dify？"} {
+					msg.Value = question
+					suggestions = append(suggestions, Suggestion{Question: question, Msg: msg})
+				}
+				return FinalAnswer(Answer{Body: "Dify 是开源的 LLM 应用开发平台。", Suggestions: suggestions}, time.Second)
+			},
+		},
+		{
+			name:    "final_empty",
+			content: func(t *testing.T) string { return Final("", 90*time.Second) },
+		},
+		{
+			name:    "error",
+			content: func(t *testing.T) string { return Error("🎤 语音识别失败，请重试或发送文字") },
+		},
+		{
+			name:    "quota_exceeded",
+			content: func(t *testing.T) string { return QuotaExceeded("") },
+		},
+		{
+			name: "role_picker",
+			content: func(t *testing.T) string {
+				return mustString(t, RolePicker("🛖 选择内置角色", "分类: **写作**", roleMsg, []string{"周报生成", "小红书文案"}))
+			},
+		},
+		{
+			name: "help",
+			content: func(t *testing.T) string {
+				return mustString(t, Help("**clear** `/clear`\n清除当前会话的上下文",
+					NewButton("清除话题", clearMsg).Danger().Confirm("清除话题", "确定清除当前会话的上下文吗？")))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := tt.content(t)
+			var got bytes.Buffer
+			if err := json.Indent(&got, []byte(content), "", "  "); err != nil {
+				t.Fatalf("card is not valid JSON: %v\n%s", err, content)
+			}
+			got.WriteByte('\n')
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %v", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("card mismatch %s\ngot:\n%s\nwant:\n%s", golden, got.String(), want)
+			}
+		})
+	}
+}
+
+func TestButtonCarriesCardMsg(t *testing.T) {
+	msg := CardMsg{Kind: "clear", ChatType: "personal", SessionId: "ou_user", MsgId: "om_msg", Value: "x"}
+	data, err := json.Marshal(NewButton("清除", msg).Primary().Build())
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	var button struct {
+		Tag   string  `json:"tag"`
+		Type  string  `json:"type"`
+		Value CardMsg `json:"value"`
+	}
+	if err := json.Unmarshal(data, &button); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if button.Tag != "button" || button.Type != "primary" {
+		t.Errorf("button = %s, want a primary button", data)
+	}
+	if button.Value != msg {
+		t.Errorf("button value = %+v, want %+v", button.Value, msg)
+	}
+}
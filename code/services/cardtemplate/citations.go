@@ -0,0 +1,109 @@
+package cardtemplate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CitationsElementID is the ID of the markdown element listing the sources
+const CitationsElementID = "citations"
+
+// Citation texts
+const (
+	CitationsTitle     = "📚 引用来源"
+	MaxCitationSnippet = 80 // 引用片段的最大显示字数
+)
+
+// Citation is a knowledge base segment the answer is based on
+type Citation struct {
+	DatasetName  string
+	DocumentName string
+	Content      string
+	Score        float64
+	URL          string // 文档链接，为空时只显示名称
+}
+
+// citationsElement returns the numbered footnotes of the citations
+func citationsElement(citations []Citation) map[string]interface{} {
+	footnotes := make([]string, 0, len(citations))
+	for i, citation := range citations {
+		footnotes = append(footnotes, formatCitation(i+1, citation))
+	}
+	// 引用块后需空行分隔，否则下一条会并入引用块
+	return map[string]interface{}{
+		"tag":        "markdown",
+		"element_id": CitationsElementID,
+		"content":    fmt.Sprintf("**%s**\n%s", CitationsTitle, strings.Join(footnotes, "\n\n")),
+		"text_size":  "notation",
+	}
+}
+
+// formatCitation renders a footnote, e.g. "[1] 手册 / [介绍.md](url) · 相关度 0.92\n> snippet"
+// Knowledge base texts are escaped, they could otherwise break the footnote
+// or inject links and mentions into the card.
+func formatCitation(n int, citation Citation) string {
+	document := escapeMarkdown(citation.DocumentName)
+	if document == "" {
+		document = "未命名文档"
+	}
+	if url := citationURL(citation.URL); url != "" {
+		document = fmt.Sprintf("[%s](%s)", document, url)
+	}
+	source := document
+	if citation.DatasetName != "" {
+		source = escapeMarkdown(citation.DatasetName) + " / " + document
+	}
+
+	line := fmt.Sprintf("[%d] %s", n, source)
+	if citation.Score > 0 {
+		line += fmt.Sprintf(" · 相关度 %.2f", citation.Score)
+	}
+	// 片段可能跨多行，合并为一行引用
+	if snippet := strings.Join(strings.Fields(citation.Content), " "); snippet != "" {
+		line += "\n> " + escapeMarkdown(truncate(snippet, MaxCitationSnippet))
+	}
+	return line
+}
+
+// markdownEscaper replaces the characters with a meaning in card markdown by
+// HTML entities, which the card renders as the plain characters
+var markdownEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"[", "&#91;",
+	"]", "&#93;",
+	"(", "&#40;",
+	")", "&#41;",
+	"*", "&#42;",
+	"_", "&#95;",
+	"~", "&#126;",
+	"`", "&#96;",
+	"#", "&#35;",
+	"|", "&#124;",
+)
+
+// escapeMarkdown escapes text so that it is shown as is in card markdown
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// urlEscaper percent-encodes the characters that would end a markdown link target
+var urlEscaper = strings.NewReplacer(
+	" ", "%20",
+	"(", "%28",
+	")", "%29",
+	"<", "%3C",
+	">", "%3E",
+)
+
+// citationURL returns the link target of a document URL, empty for URLs
+// other than http(s) ones
+func citationURL(url string) string {
+	url = strings.TrimSpace(url)
+	lower := strings.ToLower(url)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return ""
+	}
+	return urlEscaper.Replace(url)
+}
@@ -0,0 +1,50 @@
+package cardtemplate
+
+import (
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+// Interactive cards are sent as message cards of the 1.0 schema, whose
+// component values are delivered to the card action handler as CardMsg.
+
+// RolePicker returns a card with a select menu of options
+func RolePicker(title string, content string, msg CardMsg, options []string) *larkcard.MessageCard {
+	return newMessageCard(title, larkcard.TemplateIndigo,
+		larkcard.NewMessageCardMarkdown().Content(content).Build(),
+		larkcard.NewMessageCardAction().
+			Actions([]larkcard.MessageCardActionElement{
+				NewSelect("请选择", msg, options).Build(),
+			}).
+			Build())
+}
+
+// Help returns the help card, buttons are shown below the content
+func Help(content string, buttons ...*ButtonBuilder) *larkcard.MessageCard {
+	elements := []larkcard.MessageCardElement{
+		larkcard.NewMessageCardMarkdown().Content(content).Build(),
+	}
+	if len(buttons) > 0 {
+		actions := make([]larkcard.MessageCardActionElement, 0, len(buttons))
+		for _, button := range buttons {
+			actions = append(actions, button.Build())
+		}
+		elements = append(elements, larkcard.NewMessageCardAction().Actions(actions).Build())
+	}
+	return newMessageCard("🎒 需要帮助吗？", larkcard.TemplateBlue, elements...)
+}
+
+// Markdown returns a notice card with a header and a markdown body
+func Markdown(title string, template string, content string) *larkcard.MessageCard {
+	return newMessageCard(title, template, larkcard.NewMessageCardMarkdown().Content(content).Build())
+}
+
+func newMessageCard(title string, template string, elements ...larkcard.MessageCardElement) *larkcard.MessageCard {
+	return larkcard.NewMessageCard().
+		Config(larkcard.NewMessageCardConfig().WideScreenMode(true).UpdateMulti(true).Build()).
+		Header(larkcard.NewMessageCardHeader().
+			Template(template).
+			Title(larkcard.NewMessageCardPlainText().Content(title).Build()).
+			Build()).
+		Elements(elements).
+		Build()
+}
@@ -0,0 +1,111 @@
+package cardtemplate
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// StepsElementID is the ID of the collapsible panel listing the agent steps
+const StepsElementID = "agent_steps"
+
+// Agent steps panel texts
+const (
+	StepsTitle       = "🧠 推理过程 / 使用的工具"
+	ToolRunningText  = "⏳ 调用中..."
+	MaxStepFieldSize = 300 // 工具名称、输入和结果的最大显示字数
+	MaxStepThought   = 500 // 推理内容的最大显示字数
+)
+
+// AgentStep is a reasoning step of an agent with the tool it called, if any
+type AgentStep struct {
+	Thought     string
+	Tool        string
+	Input       string
+	Observation string
+}
+
+// stepsPanel returns the collapsible panel listing the steps in order
+func stepsPanel(steps []AgentStep, expanded bool) map[string]interface{} {
+	tools := 0
+	lines := make([]string, 0, len(steps))
+	for i, step := range steps {
+		lines = append(lines, formatStep(i+1, step))
+		if step.Tool != "" {
+			tools++
+		}
+	}
+
+	title := fmt.Sprintf("**%s** <font color='grey'>%d 步", StepsTitle, len(steps))
+	if tools > 0 {
+		title += fmt.Sprintf("，调用工具 %d 次", tools)
+	}
+	title += "</font>"
+
+	return map[string]interface{}{
+		"tag":        "collapsible_panel",
+		"element_id": StepsElementID,
+		"expanded":   expanded,
+		"header": map[string]interface{}{
+			"title": map[string]interface{}{
+				"tag":     "markdown",
+				"content": title,
+			},
+			"vertical_align": "center",
+			"icon": map[string]interface{}{
+				"tag":   "standard_icon",
+				"token": "down-small-ccm_outlined",
+				"size":  "16px 16px",
+			},
+			"icon_position":       "right",
+			"icon_expanded_angle": -180,
+		},
+		"border": map[string]interface{}{
+			"color":         "grey",
+			"corner_radius": "5px",
+		},
+		"vertical_spacing": "8px",
+		"padding":          "8px 8px 8px 8px",
+		"elements": []interface{}{
+			map[string]interface{}{
+				"tag":       "markdown",
+				"content":   strings.Join(lines, "\n\n"),
+				"text_size": "notation",
+			},
+		},
+	}
+}
+
+// formatStep renders a step as markdown, e.g. "**2.** 💭 thought\n🔧 **search** ...".
+// Thoughts and tool calls may quote web pages or code output, so they are
+// escaped like citations.
+func formatStep(n int, step AgentStep) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%d.**", n)
+	if thought := strings.TrimSpace(step.Thought); thought != "" {
+		fmt.Fprintf(&b, " 💭 %s", escapeMarkdown(truncate(thought, MaxStepThought)))
+	}
+	tool := strings.TrimSpace(step.Tool)
+	if tool == "" {
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "\n🔧 **%s**", escapeMarkdown(truncate(tool, MaxStepFieldSize)))
+	if input := strings.TrimSpace(step.Input); input != "" {
+		fmt.Fprintf(&b, "\n输入: %s", escapeMarkdown(truncate(input, MaxStepFieldSize)))
+	}
+	if observation := strings.TrimSpace(step.Observation); observation != "" {
+		fmt.Fprintf(&b, "\n结果: %s", escapeMarkdown(truncate(observation, MaxStepFieldSize)))
+	} else {
+		fmt.Fprintf(&b, "\n%s", ToolRunningText)
+	}
+	return b.String()
+}
+
+// truncate shortens s to at most max runes, marking the cut with "..."
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max]) + "..."
+}
@@ -0,0 +1,40 @@
+package cardtemplate
+
+import "fmt"
+
+// SuggestionsTitle is shown above the suggested follow-up questions
+const SuggestionsTitle = "💡 你可能还想问"
+
+// Suggestion is a follow-up question button, Msg is sent back when clicked
+type Suggestion struct {
+	Question string
+	Msg      CardMsg
+}
+
+// suggestionElements returns the title followed by a button per question.
+// JSON 2.0 buttons send their value with the card.action.trigger callback.
+func suggestionElements(suggestions []Suggestion) []interface{} {
+	elements := []interface{}{
+		map[string]interface{}{
+			"tag":       "markdown",
+			"content":   fmt.Sprintf("**%s**", SuggestionsTitle),
+			"text_size": "notation",
+		},
+	}
+	for _, suggestion := range suggestions {
+		elements = append(elements, map[string]interface{}{
+			"tag":   "button",
+			"text":  plainText(suggestion.Question),
+			"type":  "default",
+			"size":  "small",
+			"width": "fill",
+			"behaviors": []interface{}{
+				map[string]interface{}{
+					"type":  "callback",
+					"value": suggestion.Msg.ActionValue(),
+				},
+			},
+		})
+	}
+	return elements
+}
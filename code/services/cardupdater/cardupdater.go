@@ -0,0 +1,172 @@
+package cardupdater
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultInterval = 300 * time.Millisecond // 默认更新间隔
+	DefaultMinDelta = 20                     // 默认最小增量字数
+	DefaultQPS      = 5                      // 飞书单条消息更新QPS上限
+	UpdateTimeout   = 10 * time.Second       // 单次更新超时
+)
+
+// UpdateFunc writes content to a card
+type UpdateFunc func(ctx context.Context, content string) error
+
+// Config configures the update scheduler
+type Config struct {
+	Interval time.Duration // 两次更新的最小间隔
+	MinDelta int           // 触发更新所需的最小新增字数
+	QPS      float64       // 每张卡片每秒最多更新次数
+}
+
+// withDefaults fills unset fields with the defaults
+func (c Config) withDefaults() Config {
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.MinDelta <= 0 {
+		c.MinDelta = DefaultMinDelta
+	}
+	if c.QPS <= 0 {
+		c.QPS = DefaultQPS
+	}
+	return c
+}
+
+// minGap returns the minimum time between two updates, honoring both the interval and the QPS
+func (c Config) minGap() time.Duration {
+	gap := time.Duration(float64(time.Second) / c.QPS)
+	if gap < c.Interval {
+		return c.Interval
+	}
+	return gap
+}
+
+// Updater coalesces the updates of a single card. Update only records the
+// latest content; a worker writes it at most once per gap, so intermediate
+// states are dropped while a write is in flight. Flush always writes the
+// final content.
+type Updater struct {
+	config Config
+	update UpdateFunc
+	finish UpdateFunc
+
+	mu       sync.Mutex
+	latest   string
+	sent     string
+	sentLen  int
+	lastSent time.Time
+
+	notify   chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates an updater and starts its worker. update writes intermediate
+// content and finish writes the final content on Flush.
+func New(config Config, update UpdateFunc, finish UpdateFunc) *Updater {
+	u := &Updater{
+		config: config.withDefaults(),
+		update: update,
+		finish: finish,
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go u.run()
+	return u
+}
+
+// Update records the latest full content of the card without blocking
+func (u *Updater) Update(content string) {
+	u.mu.Lock()
+	u.latest = content
+	u.mu.Unlock()
+
+	select {
+	case u.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Flush stops the worker, waits for an in-flight write and writes the final content
+func (u *Updater) Flush(ctx context.Context) error {
+	u.Stop()
+
+	u.mu.Lock()
+	content := u.latest
+	u.mu.Unlock()
+
+	if err := u.finish(ctx, content); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.sent = content
+	u.lastSent = time.Now()
+	u.mu.Unlock()
+	return nil
+}
+
+// Stop stops the worker without writing pending content
+func (u *Updater) Stop() {
+	u.stopOnce.Do(func() {
+		close(u.stop)
+	})
+	<-u.done
+}
+
+// run writes pending content whenever the gap since the last write has passed
+func (u *Updater) run() {
+	defer close(u.done)
+
+	gap := u.config.minGap()
+	// 增量不足时最多等待该时间后仍然更新，避免输出停顿时卡片长时间不变
+	maxWait := 3 * gap
+	ticker := time.NewTicker(gap)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-u.notify:
+		case <-ticker.C:
+		}
+
+		u.mu.Lock()
+		content := u.latest
+		pending := content != u.sent
+		delta := utf8.RuneCountInString(content) - u.sentLen
+		since := time.Since(u.lastSent)
+		u.mu.Unlock()
+
+		if !pending || since < gap {
+			continue
+		}
+		if delta < u.config.MinDelta && delta >= 0 && since < maxWait {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), UpdateTimeout)
+		err := u.update(ctx, content)
+		cancel()
+		if err != nil {
+			// 单次更新失败不中断，后续更新会写入更新的内容
+			log.Printf("[CardUpdater] Failed to update card: %v", err)
+		}
+
+		u.mu.Lock()
+		u.sent = content
+		u.sentLen = utf8.RuneCountInString(content)
+		u.lastSent = time.Now()
+		u.mu.Unlock()
+	}
+}
@@ -0,0 +1,102 @@
+package cardupdater
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu      sync.Mutex
+	updates []string
+	times   []time.Time
+	final   []string
+}
+
+func (r *recorder) update(ctx context.Context, content string) error {
+	r.mu.Lock()
+	r.updates = append(r.updates, content)
+	r.times = append(r.times, time.Now())
+	r.mu.Unlock()
+	time.Sleep(20 * time.Millisecond) // 模拟接口耗时
+	return nil
+}
+
+func (r *recorder) finish(ctx context.Context, content string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.final = append(r.final, content)
+	return nil
+}
+
+func TestUpdaterCoalescesAndFlushes(t *testing.T) {
+	r := &recorder{}
+	u := New(Config{Interval: 30 * time.Millisecond, MinDelta: 1, QPS: 100}, r.update, r.finish)
+
+	var content strings.Builder
+	for i := 0; i < 200; i++ {
+		content.WriteString("字")
+		u.Update(content.String())
+		time.Sleep(time.Millisecond)
+	}
+	if err := u.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.updates) == 0 || len(r.updates) >= 200 {
+		t.Errorf("got %d updates, want coalesced updates", len(r.updates))
+	}
+	for i := 1; i < len(r.updates); i++ {
+		if len(r.updates[i]) <= len(r.updates[i-1]) {
+			t.Errorf("update %d is not newer than the previous one", i)
+		}
+	}
+	if len(r.final) != 1 || r.final[0] != content.String() {
+		t.Errorf("got %d final writes, want one with the full content", len(r.final))
+	}
+}
+
+func TestUpdaterRespectsQPS(t *testing.T) {
+	r := &recorder{}
+	u := New(Config{Interval: time.Millisecond, MinDelta: 1, QPS: 20}, r.update, r.finish)
+
+	deadline := time.Now().Add(400 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ {
+		u.Update(strings.Repeat("a", i+1))
+		time.Sleep(2 * time.Millisecond)
+	}
+	u.Stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	minGap := 50 * time.Millisecond
+	for i := 1; i < len(r.times); i++ {
+		if gap := r.times[i].Sub(r.times[i-1]); gap < minGap-5*time.Millisecond {
+			t.Errorf("updates %d and %d are %v apart, want at least %v", i-1, i, gap, minGap)
+		}
+	}
+	if len(r.final) != 0 {
+		t.Errorf("Stop() wrote final content")
+	}
+}
+
+func TestUpdaterMinDelta(t *testing.T) {
+	r := &recorder{}
+	u := New(Config{Interval: 50 * time.Millisecond, MinDelta: 100, QPS: 100}, r.update, r.finish)
+
+	u.Update(strings.Repeat("a", 150))
+	time.Sleep(30 * time.Millisecond)
+	u.Update(strings.Repeat("a", 160))
+	time.Sleep(70 * time.Millisecond)
+	u.Stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.updates) != 1 {
+		t.Errorf("got %d updates, want 1 for a small delta within the max wait", len(r.updates))
+	}
+}
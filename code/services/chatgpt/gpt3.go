@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"github.com/sashabaranov/go-openai"
 	"io"
-	"start-feishubot/initialization"
+	"start-feishubot/services/ai"
 	customOpenai "start-feishubot/services/openai"
 )
 
@@ -16,7 +16,7 @@ type Messages struct {
 }
 
 type ChatGPT struct {
-	config *initialization.Config
+	config *customOpenai.Config
 }
 
 type Gpt3 interface {
@@ -24,10 +24,13 @@ type Gpt3 interface {
 	StreamChatWithHistory() error
 }
 
-func NewGpt3(config *initialization.Config) *ChatGPT {
+// NewGpt3 creates a chat client of the OpenAI API, the timeout of its HTTP
+// client is the one of the openai package config, see customOpenai.InitConfig
+func NewGpt3(config *customOpenai.Config) *ChatGPT {
 	return &ChatGPT{config: config}
 }
 
+// StreamChat streams the answer text, see StreamChatWithHistory for the events
 func (c *ChatGPT) StreamChat(ctx context.Context,
 	msg []customOpenai.Messages,
 	responseStream chan string) error {
@@ -39,12 +42,14 @@ func (c *ChatGPT) StreamChat(ctx context.Context,
 			Content: m.Content,
 		}
 	}
-	return c.StreamChatWithHistory(ctx, chatMsgs, 2000,
-		responseStream)
+	return ai.ForwardText(ctx, func(events chan<- ai.StreamEvent) error {
+		return c.StreamChatWithHistory(ctx, chatMsgs, 2000, events)
+	}, responseStream)
 }
 
+// StreamChatWithHistory streams the answer as delta events followed by a final event
 func (c *ChatGPT) StreamChatWithHistory(ctx context.Context, msg []openai.ChatCompletionMessage, maxTokens int,
-	responseStream chan string,
+	events chan<- ai.StreamEvent,
 ) error {
 	config := openai.DefaultConfig(c.config.OpenaiApiKeys[0])
 	config.BaseURL = c.config.OpenaiApiUrl + "/v1"
@@ -69,22 +74,28 @@ func (c *ChatGPT) StreamChatWithHistory(ctx context.Context, msg []openai.ChatCo
 	}
 	stream, err := client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		fmt.Errorf("CreateCompletionStream returned error: %v", err)
+		return fmt.Errorf("CreateCompletionStream returned error: %v", err)
 	}
 
 	defer stream.Close()
+	messageID := ""
 	for {
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
 			//fmt.Println("Stream finished")
-			return nil
+			return ai.SendEvent(ctx, events, ai.FinalEvent{MessageID: messageID})
 		}
 		if err != nil {
 			fmt.Printf("Stream error: %v\n", err)
 			return err
 		}
-		responseStream <- response.Choices[0].Delta.Content
+		messageID = response.ID
+		if len(response.Choices) == 0 || response.Choices[0].Delta.Content == "" {
+			continue
+		}
+		if err := ai.SendEvent(ctx, events, ai.DeltaEvent{Text: response.Choices[0].Delta.Content}); err != nil {
+			return err
+		}
 	}
-	return nil
 
 }
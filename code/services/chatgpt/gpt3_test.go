@@ -2,61 +2,114 @@ package chatgpt
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"start-feishubot/initialization"
-	"start-feishubot/services/openai"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"start-feishubot/services/ai"
+	customOpenai "start-feishubot/services/openai"
 	"testing"
-	"time"
 )
 
+// newFakeOpenAI starts a chat completions server streaming the chunks and
+// returns a client of it
+func newFakeOpenAI(t *testing.T, chunks ...string) *ChatGPT {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Model    string `json:"model"`
+			Stream   bool   `json:"stream"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Stream || len(req.Messages) == 0 {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":%q,"+
+				"\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", req.Model, chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+
+	config := &customOpenai.Config{
+		OpenaiApiKeys: []string{"sk-test"},
+		OpenaiApiUrl:  server.URL,
+		OpenaiModel:   "gpt-3.5-turbo",
+	}
+	customOpenai.InitConfig(config)
+	return NewGpt3(config)
+}
+
 func TestChatGPT_streamChat(t *testing.T) {
-	// 初始化配置
-	config := initialization.LoadConfig("../../config.yaml")
-
-	// 准备测试用例
-	testCases := []struct {
-		msg        []openai.Messages
-		wantOutput string
-		wantErr    bool
-	}{
+	c := newFakeOpenAI(t, "领导，", "我想请假一天")
+	msg := []customOpenai.Messages{
 		{
-			msg: []openai.Messages{
-				{
-					Role:    "system",
-					Content: "从现在起你要化身职场语言大师，你需要用婉转的方式回复老板想你提出的问题，或像领导提出请求。",
-				},
-				{
-					Role:    "user",
-					Content: "领导，我想请假一天",
-				},
-			},
-			wantOutput: "",
-			wantErr:    false,
+			Role:    "system",
+			Content: "从现在起你要化身职场语言大师，你需要用婉转的方式回复老板想你提出的问题，或像领导提出请求。",
+		},
+		{
+			Role:    "user",
+			Content: "领导，我想请假一天",
 		},
 	}
 
-	// 执行测试用例
-	for _, tc := range testCases {
-		// 准备输入和输出
-		responseStream := make(chan string)
-		ctx := context.Background()
-		c := &ChatGPT{config: config}
+	responseStream := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StreamChat(context.Background(), msg, responseStream)
+	}()
 
-		// 启动一个协程来模拟流式聊天
-		go func() {
-			err := c.StreamChat(ctx, tc.msg, responseStream)
+	var got []string
+	for {
+		select {
+		case text := <-responseStream:
+			got = append(got, text)
+			continue
+		case err := <-done:
 			if err != nil {
-				t.Errorf("streamChat() error = %v, wantErr %v", err, tc.wantErr)
+				t.Fatalf("StreamChat() error = %v", err)
 			}
-		}()
+		}
+		break
+	}
+	if want := []string{"领导，", "我想请假一天"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamChat() texts = %q, want %q", got, want)
+	}
+}
 
-		// 等待输出并检查是否符合预期
-		select {
-		case gotOutput := <-responseStream:
-			fmt.Printf("gotOutput: %v\n", gotOutput)
+func TestChatGPT_streamChatEvents(t *testing.T) {
+	c := newFakeOpenAI(t, "你好", "", "！")
 
-		case <-time.After(5 * time.Second):
-			t.Errorf("streamChat() timeout, expected output not received")
-		}
+	events := make(chan ai.StreamEvent, 10)
+	msg := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "你好"}}
+	if err := c.StreamChatWithHistory(context.Background(), msg, 100, events); err != nil {
+		t.Fatalf("StreamChatWithHistory() error = %v", err)
+	}
+	close(events)
+
+	var got []ai.StreamEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	// 空的增量不转发
+	want := []ai.StreamEvent{
+		ai.DeltaEvent{Text: "你好"},
+		ai.DeltaEvent{Text: "！"},
+		ai.FinalEvent{MessageID: "chatcmpl-1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamChatWithHistory() events = %#v, want %#v", got, want)
 	}
 }
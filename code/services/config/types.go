@@ -6,6 +6,7 @@ type Config interface {
 	GetFeishuAppID() string
 	GetFeishuAppSecret() string
 	GetFeishuAppVerificationToken() string
+	GetFeishuAppEncryptKey() string
 
 	// Dify configuration
 	GetDifyAPIEndpoint() string
@@ -14,6 +15,28 @@ type Config interface {
 	// HTTP configuration
 	GetHttpPort() string
 
+	// Session configuration
+	GetSessionScope() string
+	GetGroupReplyMode() string
+	GetGroupKeywordPrefix() string
+
+	// Speech to text configuration
+	GetSpeechToText() string
+	GetOpenaiApiKey() string
+	GetOpenaiApiUrl() string
+
+	// Merged forward configuration
+	GetTranscriptChunkSize() int
+
+	// Card configuration
+	GetCardMode() string
+	GetCardUpdateIntervalMs() int
+	GetCardUpdateMinDelta() int
+	GetCardUpdateQPS() float64
+	GetCardPoolMinSize() int
+	GetCardPoolMaxSize() int
+	GetAgentThoughts() string
+
 	// General configuration
 	IsInitialized() bool
 }
@@ -23,9 +46,24 @@ type ConfigImpl struct {
 	FeishuAppID                 string `json:"feishu_app_id"`
 	FeishuAppSecret            string `json:"feishu_app_secret"`
 	FeishuAppVerificationToken string `json:"feishu_app_verification_token"`
+	FeishuAppEncryptKey        string `json:"feishu_app_encrypt_key"`
 	DifyAPIEndpoint            string `json:"dify_api_endpoint"`
 	DifyAPIKey                 string `json:"dify_api_key"`
 	HttpPort                   string `json:"http_port"`
+	SessionScope               string `json:"session_scope"`
+	GroupReplyMode             string `json:"group_reply_mode"`
+	GroupKeywordPrefix         string `json:"group_keyword_prefix"`
+	SpeechToText               string `json:"speech_to_text"`
+	OpenaiApiKey               string `json:"openai_api_key"`
+	OpenaiApiUrl               string `json:"openai_api_url"`
+	TranscriptChunkSize        int    `json:"transcript_chunk_size"`
+	CardMode                   string `json:"card_mode"`
+	CardUpdateIntervalMs       int    `json:"card_update_interval_ms"`
+	CardUpdateMinDelta         int    `json:"card_update_min_delta"`
+	CardUpdateQPS              float64 `json:"card_update_qps"`
+	CardPoolMinSize            int    `json:"card_pool_min_size"`
+	CardPoolMaxSize            int    `json:"card_pool_max_size"`
+	AgentThoughts              string `json:"agent_thoughts"`
 	Initialized               bool   `json:"-"`
 }
 
@@ -41,6 +79,10 @@ func (c *ConfigImpl) GetFeishuAppVerificationToken() string {
 	return c.FeishuAppVerificationToken
 }
 
+func (c *ConfigImpl) GetFeishuAppEncryptKey() string {
+	return c.FeishuAppEncryptKey
+}
+
 func (c *ConfigImpl) GetDifyAPIEndpoint() string {
 	return c.DifyAPIEndpoint
 }
@@ -53,6 +95,62 @@ func (c *ConfigImpl) GetHttpPort() string {
 	return c.HttpPort
 }
 
+func (c *ConfigImpl) GetSessionScope() string {
+	return c.SessionScope
+}
+
+func (c *ConfigImpl) GetGroupReplyMode() string {
+	return c.GroupReplyMode
+}
+
+func (c *ConfigImpl) GetGroupKeywordPrefix() string {
+	return c.GroupKeywordPrefix
+}
+
+func (c *ConfigImpl) GetSpeechToText() string {
+	return c.SpeechToText
+}
+
+func (c *ConfigImpl) GetOpenaiApiKey() string {
+	return c.OpenaiApiKey
+}
+
+func (c *ConfigImpl) GetOpenaiApiUrl() string {
+	return c.OpenaiApiUrl
+}
+
+func (c *ConfigImpl) GetTranscriptChunkSize() int {
+	return c.TranscriptChunkSize
+}
+
+func (c *ConfigImpl) GetCardMode() string {
+	return c.CardMode
+}
+
+func (c *ConfigImpl) GetCardUpdateIntervalMs() int {
+	return c.CardUpdateIntervalMs
+}
+
+func (c *ConfigImpl) GetCardUpdateMinDelta() int {
+	return c.CardUpdateMinDelta
+}
+
+func (c *ConfigImpl) GetCardUpdateQPS() float64 {
+	return c.CardUpdateQPS
+}
+
+func (c *ConfigImpl) GetCardPoolMinSize() int {
+	return c.CardPoolMinSize
+}
+
+func (c *ConfigImpl) GetCardPoolMaxSize() int {
+	return c.CardPoolMaxSize
+}
+
+func (c *ConfigImpl) GetAgentThoughts() string {
+	return c.AgentThoughts
+}
+
 func (c *ConfigImpl) IsInitialized() bool {
 	return c.Initialized
 }
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 	"start-feishubot/services/ai"
@@ -19,11 +20,57 @@ type MessageCache interface {
 type CardCreator interface {
 	CreateCardEntity(ctx context.Context, content string) (string, error)
 	UpdateCardContent(ctx context.Context, cardID string, content string) (string, error)
+	ReplyCard(ctx context.Context, messageID string, content string) (string, error)
+	// ReplyCardEntity sends a card entity created ahead of time as a reply to the message
+	ReplyCardEntity(ctx context.Context, messageID string, cardID string) (string, error)
+	// FinishCardContent writes the final content and ends streaming of the card
+	FinishCardContent(ctx context.Context, cardID string, content string) error
+}
+
+// MessageResource is an image or file attached to a message
+type MessageResource struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+// ErrResourceTooLarge is returned when a resource exceeds the size limit of the download
+var ErrResourceTooLarge = errors.New("resource too large")
+
+// ResourceDownloader downloads the resources attached to messages
+type ResourceDownloader interface {
+	// DownloadResource downloads a resource of at most maxSize bytes, larger
+	// resources are rejected with ErrResourceTooLarge without being buffered
+	DownloadResource(ctx context.Context, messageID string, fileKey string, resourceType string, maxSize int64) (*MessageResource, error)
+}
+
+// FetchedMessage is a message read back through the IM API
+type FetchedMessage struct {
+	MessageID  string
+	MsgType    string
+	Content    string
+	SenderID   string
+	SenderType string // user 或 app
+	CreateTime string // 毫秒时间戳
+}
+
+// MessageReader reads existing messages
+type MessageReader interface {
+	GetMessage(ctx context.Context, messageID string) (*FetchedMessage, error)
+	// GetMergeForward returns the messages bundled in a merge_forward message
+	GetMergeForward(ctx context.Context, messageID string) ([]*FetchedMessage, error)
+	// GetUserName returns the name of the user with the open_id
+	GetUserName(ctx context.Context, openID string) (string, error)
+}
+
+// Transcriber converts speech audio to text
+type Transcriber interface {
+	Transcribe(ctx context.Context, userID string, wavPath string) (string, error)
 }
 
 // AIProvider interface for AI services
 type AIProvider interface {
-	StreamChat(ctx context.Context, messages []ai.Message, responseStream chan string) error
+	StreamChat(ctx context.Context, messages []ai.Message, events chan<- ai.StreamEvent) error
 }
 
 // SessionStats contains session statistics
@@ -45,6 +92,34 @@ const (
 	ModeGPT       SessionMode = "gpt"
 )
 
+// SessionScope defines how messages are grouped into sessions
+type SessionScope string
+
+const (
+	ScopeChat      SessionScope = "chat"       // 每个单聊/群聊一个会话
+	ScopeThread    SessionScope = "thread"     // 每个话题一个会话，话题外的消息按聊天划分
+	ScopeUser      SessionScope = "user"       // 每个用户一个会话，跨所有聊天
+	ScopeGroupUser SessionScope = "group_user" // 群聊中每个用户一个会话，单聊按聊天划分
+)
+
+// GroupReplyMode defines when the bot replies in group chats
+type GroupReplyMode string
+
+const (
+	GroupReplyAlways  GroupReplyMode = "always"  // 回复所有群消息
+	GroupReplyMention GroupReplyMode = "mention" // 仅回复@机器人的消息
+	GroupReplyKeyword GroupReplyMode = "keyword" // 回复@机器人或以关键词开头的消息
+)
+
+// AgentThoughtsMode defines how the answer card shows the steps of agent apps
+type AgentThoughtsMode string
+
+const (
+	AgentThoughtsCollapsed AgentThoughtsMode = "collapsed" // 折叠面板显示推理过程和工具调用
+	AgentThoughtsExpanded  AgentThoughtsMode = "expanded"  // 默认展开面板
+	AgentThoughtsHidden    AgentThoughtsMode = "hidden"    // 不显示
+)
+
 // SessionMeta contains session metadata
 type SessionMeta struct {
 	Mode           SessionMode  `json:"mode"`
@@ -59,6 +134,16 @@ type SessionMeta struct {
 	MessageId      string      `json:"message_id,omitempty"`
 	ConversationID string      `json:"conversation_id,omitempty"`
 	CacheAddress   string      `json:"cache_address,omitempty"`
+	Documents      []ai.File   `json:"documents,omitempty"`
+	Usage          TokenUsage  `json:"usage"`
+}
+
+// TokenUsage contains the tokens used by the answers of a session
+type TokenUsage struct {
+	Answers          int `json:"answers"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 // SessionCache interface for session management
@@ -79,6 +164,10 @@ type SessionCache interface {
 	IsDuplicateMessage(userId string, messageId string) bool
 	GetCardID(sessionId string, userId string, messageId string) (string, error)
 	GetSessionInfo(userId string, messageId string) (*SessionMeta, error)
+	AddDocument(sessionId string, userId string, doc ai.File) error
+	GetDocuments(sessionId string) []ai.File
+	AddUsage(sessionId string, usage TokenUsage)
+	GetUsage(sessionId string) TokenUsage
 }
 
 // Basic MessageCache implementation
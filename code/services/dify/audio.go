@@ -0,0 +1,41 @@
+package dify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"start-feishubot/services/ai"
+)
+
+// audioToTextResponse is the response of /audio-to-text
+type audioToTextResponse struct {
+	Text string `json:"text"`
+}
+
+// AudioToText transcribes the audio file with the speech to text model of
+// the app, which must enable speech to text. Dify accepts mp3, mp4, mpeg,
+// mpga, m4a, wav and webm files of at most 15MB.
+func (d *DifyClient) AudioToText(ctx context.Context, userID string, file ai.File) (string, error) {
+	if userID == "" {
+		userID = DefaultUser
+	}
+	body, contentType, err := newFileForm(userID, file)
+	if err != nil {
+		return "", err
+	}
+	req, err := d.newRequest(ctx, http.MethodPost, "/audio-to-text", body, contentType)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.do(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result audioToTextResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", ai.WrapError(ai.ErrCodeUnavailable, "failed to decode transcription", err)
+	}
+	return result.Text, nil
+}
@@ -2,8 +2,12 @@ package dify
 
 import (
 	"start-feishubot/services/config"
+	"strings"
 )
 
+// APIVersionPath is the path of the Dify service API under the server address
+const APIVersionPath = "/v1"
+
 // ConfigAdapter adapts the config interface for Dify services
 type ConfigAdapter struct {
 	config config.Config
@@ -16,9 +20,15 @@ func NewConfigAdapter(config config.Config) *ConfigAdapter {
 	}
 }
 
-// GetAPIEndpoint returns the Dify API endpoint
+// GetAPIEndpoint returns the Dify API endpoint without trailing slash.
+// Both the server address (https://api.dify.ai) and the API base URL
+// (https://api.dify.ai/v1) are accepted, the version path is appended when missing.
 func (c *ConfigAdapter) GetAPIEndpoint() string {
-	return c.config.GetDifyAPIEndpoint()
+	endpoint := strings.TrimRight(c.config.GetDifyAPIEndpoint(), "/")
+	if !strings.HasSuffix(endpoint, APIVersionPath) {
+		endpoint += APIVersionPath
+	}
+	return endpoint
 }
 
 // GetAPIKey returns the Dify API key
@@ -2,158 +2,460 @@ package dify
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strings"
 	"start-feishubot/services/ai"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultUser           = "feishu-bot"     // 消息未指定用户时使用的Dify用户
+	MaxRetries            = 2                // 临时错误的最大重试次数
+	RetryInterval         = 1 * time.Second  // 重试间隔，按重试次数递增
+	ResponseHeaderTimeout = 60 * time.Second // 等待响应头的超时时间
+)
+
+// Dify SSE events
+const (
+	EventMessage        = "message"
+	EventAgentMessage   = "agent_message"
+	EventAgentThought   = "agent_thought"
+	EventMessageFile    = "message_file"
+	EventMessageReplace = "message_replace"
+	EventMessageEnd     = "message_end"
+	EventError          = "error"
+	EventPing           = "ping"
 )
 
-// DifyClient implements core.AIProvider interface
+// DifyClient implements ai.Provider with the chat-messages API of a Dify app.
+// The client keeps no state per session: the Dify conversation of a session
+// is stored in the session and passed in the message metadata, so clearing
+// the session starts a new conversation.
 type DifyClient struct {
-	config *ConfigAdapter
+	config     *ConfigAdapter
+	httpClient *http.Client
 }
 
 // NewDifyClient creates a new Dify client
 func NewDifyClient(config *ConfigAdapter) *DifyClient {
 	return &DifyClient{
 		config: config,
+		httpClient: &http.Client{
+			// 流式响应时间不定，整体超时由调用方的上下文控制
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: ResponseHeaderTimeout,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+	}
+}
+
+// chatRequest is the body of /chat-messages
+type chatRequest struct {
+	Inputs         map[string]interface{}   `json:"inputs"`
+	Query          string                   `json:"query"`
+	ResponseMode   string                   `json:"response_mode"`
+	ConversationID string                   `json:"conversation_id,omitempty"`
+	User           string                   `json:"user"`
+	Files          []map[string]interface{} `json:"files,omitempty"`
+}
+
+// streamEvent is a server-sent event of a streaming chat
+type streamEvent struct {
+	Event          string `json:"event"`
+	TaskID         string `json:"task_id"`
+	MessageID      string `json:"message_id"`
+	ConversationID string `json:"conversation_id"`
+	Answer         string `json:"answer"`
+
+	// agent_thought和message_file事件的字段
+	ID          string `json:"id"`
+	Position    int    `json:"position"`
+	Thought     string `json:"thought"`
+	Observation string `json:"observation"`
+	Tool        string `json:"tool"`
+	ToolInput   string `json:"tool_input"`
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	BelongsTo   string `json:"belongs_to"`
+
+	// message_end事件的字段
+	Metadata struct {
+		Usage              *usage              `json:"usage"`
+		RetrieverResources []retrieverResource `json:"retriever_resources"`
+	} `json:"metadata"`
+
+	// error事件的字段
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// usage is the token usage reported on message_end
+type usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	TotalPrice       string  `json:"total_price"`
+	Currency         string  `json:"currency"`
+	Latency          float64 `json:"latency"`
+}
+
+// retrieverResource is a knowledge base segment reported on message_end
+type retrieverResource struct {
+	Position     int     `json:"position"`
+	DatasetID    string  `json:"dataset_id"`
+	DatasetName  string  `json:"dataset_name"`
+	DocumentID   string  `json:"document_id"`
+	DocumentName string  `json:"document_name"`
+	SegmentID    string  `json:"segment_id"`
+	Score        float64 `json:"score"`
+	Content      string  `json:"content"`
+
+	// 知识库文档的元数据，网页来源的文档可在其中配置链接
+	DocMetadata map[string]interface{} `json:"doc_metadata"`
+}
+
+// URLMetadataKeys are the document metadata fields holding the source link
+var URLMetadataKeys = []string{"url", "source_url", "source"}
+
+// url returns the source link of the document, if its metadata has one
+func (r retrieverResource) url() string {
+	for _, key := range URLMetadataKeys {
+		if value, ok := r.DocMetadata[key].(string); ok && (strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")) {
+			return value
+		}
 	}
+	return ""
 }
 
-// StreamChat implements core.AIProvider interface
-func (d *DifyClient) StreamChat(ctx context.Context, messages []ai.Message, responseStream chan string) error {
-	// Get the last message as query
+// errorResponse is the body of a failed API request
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+// StreamChat implements ai.Provider interface.
+// The last message is the query, the Dify conversation in its metadata keeps
+// the context and the FinalEvent reports the conversation to continue next.
+// Temporary errors are retried as long as nothing was streamed yet.
+func (d *DifyClient) StreamChat(ctx context.Context, messages []ai.Message, events chan<- ai.StreamEvent) error {
 	if len(messages) == 0 {
-		return fmt.Errorf("no messages provided")
+		return ai.WrapError(ai.ErrCodeInvalidRequest, "no messages provided", nil)
+	}
+	for i := range messages {
+		if err := messages[i].Validate(); err != nil {
+			return ai.WrapError(ai.ErrCodeInvalidRequest, fmt.Sprintf("invalid message at index %d", i), err)
+		}
 	}
-	query := messages[len(messages)-1].Content
+	lastMsg := messages[len(messages)-1]
 
 	// Convert previous messages to history
-	var history string
+	history := "null"
 	if len(messages) > 1 {
-		historyMessages := messages[:len(messages)-1]
-		historyBytes, err := json.Marshal(historyMessages)
+		historyBytes, err := json.Marshal(messages[:len(messages)-1])
 		if err != nil {
-			return fmt.Errorf("failed to marshal history: %v", err)
+			return ai.WrapError(ai.ErrCodeInvalidRequest, "failed to marshal history", err)
 		}
 		history = string(historyBytes)
-	} else {
-		history = "null"
 	}
 
-	// Prepare request body
-	requestBody := map[string]interface{}{
-		"inputs": map[string]interface{}{
+	// User and conversation come from the last message metadata
+	userID := lastMsg.Metadata["user_id"]
+	if userID == "" {
+		userID = DefaultUser
+	}
+	conversationID := lastMsg.Metadata["conversation_id"]
+	prompt := systemPrompt(messages[:len(messages)-1])
+
+	req := chatRequest{
+		Inputs: map[string]interface{}{
 			"history": history,
 		},
-		"query":         query,
-		"response_mode": "streaming",
-		"user":         ctx.Value("user_id").(string),
+		Query:          withSystemPrompt(prompt, conversationID, lastMsg.Content),
+		ResponseMode:   "streaming",
+		ConversationID: conversationID,
+		User:           userID,
 	}
 
-	// Add conversation_id if exists
-	if conversationID := ctx.Value("conversation_id"); conversationID != nil {
-		requestBody["conversation_id"] = conversationID.(string)
+	// Upload attached files once for all attempts
+	if len(lastMsg.Files) > 0 {
+		files, err := d.uploadFiles(ctx, userID, lastMsg.Files)
+		if err != nil {
+			return err
+		}
+		req.Files = files
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %v", err)
-	}
+	for attempt := 0; ; attempt++ {
+		sent, err := d.streamOnce(ctx, req, events)
+		if err == nil {
+			return nil
+		}
 
-	log.Printf("Sending request to Dify: %s", string(jsonBody))
+		// 远端会话已失效时开启新会话，新会话ID随FinalEvent返回
+		if !sent && req.ConversationID != "" && errors.Is(err, ai.ErrConversationNotFound) {
+			log.Printf("[Dify] Conversation %s not found, starting a new one", req.ConversationID)
+			req.ConversationID = ""
+			req.Query = withSystemPrompt(prompt, "", lastMsg.Content)
+			continue
+		}
 
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, "POST", d.config.GetAPIEndpoint()+"/chat-messages", strings.NewReader(string(jsonBody)))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		if sent || attempt >= MaxRetries || !ai.IsTemporary(err) {
+			return err
+		}
+		delay := time.Duration(attempt+1) * RetryInterval
+		log.Printf("[Dify] Request failed (attempt %d/%d), retrying in %v: %v", attempt+1, MaxRetries+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
 	}
+}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+d.config.GetAPIKey())
+// systemPrompt joins the system messages, e.g. the role chosen for the session
+func systemPrompt(messages []ai.Message) string {
+	var prompts []string
+	for _, msg := range messages {
+		if msg.Role == "system" && strings.TrimSpace(msg.Content) != "" {
+			prompts = append(prompts, strings.TrimSpace(msg.Content))
+		}
+	}
+	return strings.Join(prompts, "\n\n")
+}
 
-	log.Printf("Sending request to URL: %s", req.URL.String())
-	log.Printf("Request headers: %v", req.Header)
+// withSystemPrompt prepends the system prompt to the first query of a new
+// conversation. Dify reads app inputs only on the first message, so the
+// prompt has to come with the query, and the conversation remembers it.
+func withSystemPrompt(prompt string, conversationID string, query string) string {
+	if prompt == "" || conversationID != "" {
+		return query
+	}
+	return prompt + "\n\n" + query
+}
 
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// streamOnce sends one chat request and forwards its events.
+// It reports whether any event was sent, after which retrying would
+// duplicate the answer.
+func (d *DifyClient) streamOnce(ctx context.Context, chatReq chatRequest, events chan<- ai.StreamEvent) (bool, error) {
+	body, err := json.Marshal(chatReq)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return false, ai.WrapError(ai.ErrCodeInvalidRequest, "failed to marshal request", err)
 	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	req, err := d.newRequest(ctx, http.MethodPost, "/chat-messages", bytes.NewReader(body), "application/json")
+	if err != nil {
+		return false, err
 	}
+	req.Header.Set("Accept", "text/event-stream")
 
-	log.Printf("Response status: %d", resp.StatusCode)
-	log.Printf("Response headers: %v", resp.Header)
+	resp, err := d.do(ctx, req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
 
-	// Read response stream
+	sent := false
 	reader := bufio.NewReader(resp.Body)
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				log.Printf("Stream ended")
-				break
+		line, readErr := reader.ReadString('\n')
+		if data, ok := parseSSEData(line); ok {
+			event, err := parseStreamEvent(data)
+			if err != nil {
+				return sent, err
 			}
-			return fmt.Errorf("failed to read response: %v", err)
-		}
 
-		// Skip empty lines
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+			if event.Event == EventError {
+				return sent, newAPIError(event.Status, event.Code, event.Message)
+			}
+			for _, e := range convertEvent(event) {
+				if err := ai.SendEvent(ctx, events, e); err != nil {
+					return sent, err
+				}
+				sent = true
+			}
+			if event.Event == EventMessageEnd {
+				return sent, nil
+			}
 		}
 
-		log.Printf("Received line: %s", line)
+		if readErr != nil {
+			if ctx.Err() != nil {
+				return sent, ctx.Err()
+			}
+			if readErr == io.EOF {
+				return sent, ai.WrapError(ai.ErrCodeStream, "stream ended before message_end", nil)
+			}
+			return sent, ai.WrapError(ai.ErrCodeStream, "failed to read stream", readErr)
+		}
+	}
+}
 
-		// Parse SSE data
-		if !strings.HasPrefix(line, "data: ") {
-			continue
+// convertEvent converts a Dify event into the stream events it carries
+func convertEvent(event *streamEvent) []ai.StreamEvent {
+	switch event.Event {
+	case EventMessage, EventAgentMessage:
+		if event.Answer == "" {
+			return nil
 		}
-		data := strings.TrimPrefix(line, "data: ")
+		return []ai.StreamEvent{ai.DeltaEvent{Text: event.Answer}}
 
-		// Parse JSON
-		var response struct {
-			Event   string `json:"event"`
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
+	case EventAgentThought:
+		var converted []ai.StreamEvent
+		if event.Thought != "" {
+			converted = append(converted, ai.ThoughtEvent{
+				ID:       event.ID,
+				Position: event.Position,
+				Thought:  event.Thought,
+			})
 		}
-		if err := json.Unmarshal([]byte(data), &response); err != nil {
-			log.Printf("Failed to parse response: %v", err)
-			return fmt.Errorf("failed to parse response: %v", err)
+		if event.Tool != "" {
+			converted = append(converted, ai.ToolCallEvent{
+				ID:          event.ID,
+				Position:    event.Position,
+				Tool:        event.Tool,
+				Input:       event.ToolInput,
+				Observation: event.Observation,
+			})
 		}
+		return converted
 
-		log.Printf("Parsed response: event=%s, content=%s", response.Event, response.Message.Content)
+	case EventMessageFile:
+		return []ai.StreamEvent{ai.FileEvent{
+			ID:        event.ID,
+			Type:      event.Type,
+			URL:       event.URL,
+			BelongsTo: event.BelongsTo,
+		}}
 
-		// Send content to stream
-		if response.Event == "message" && response.Message.Content != "" {
-			select {
-			case <-ctx.Done():
-				log.Printf("Context cancelled")
-				return ctx.Err()
-			case responseStream <- response.Message.Content:
-				log.Printf("Sent content to stream: %s", response.Message.Content)
-			}
+	case EventMessageEnd:
+		var converted []ai.StreamEvent
+		if u := event.Metadata.Usage; u != nil {
+			converted = append(converted, ai.UsageEvent{
+				PromptTokens:     u.PromptTokens,
+				CompletionTokens: u.CompletionTokens,
+				TotalTokens:      u.TotalTokens,
+				TotalPrice:       u.TotalPrice,
+				Currency:         u.Currency,
+				Latency:          u.Latency,
+			})
+		}
+		for _, r := range event.Metadata.RetrieverResources {
+			converted = append(converted, ai.CitationEvent{
+				Position:     r.Position,
+				DatasetID:    r.DatasetID,
+				DatasetName:  r.DatasetName,
+				DocumentID:   r.DocumentID,
+				DocumentName: r.DocumentName,
+				SegmentID:    r.SegmentID,
+				Content:      r.Content,
+				Score:        r.Score,
+				URL:          r.url(),
+			})
 		}
+		return append(converted, ai.FinalEvent{
+			MessageID:      event.MessageID,
+			ConversationID: event.ConversationID,
+		})
+
+	case EventMessageReplace:
+		// 内容审查替换了整个回答
+		return []ai.StreamEvent{ai.ReplaceEvent{Text: event.Answer}}
 	}
 
+	// ping、workflow节点、tts_message等事件不转发
 	return nil
 }
 
-// Close implements core.AIProvider interface
+// parseSSEData returns the data of an SSE data line, other lines are ignored
+func parseSSEData(line string) (string, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "data:") {
+		return "", false
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	return data, data != ""
+}
+
+// parseStreamEvent decodes the JSON data of an SSE event
+func parseStreamEvent(data string) (*streamEvent, error) {
+	var event streamEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return nil, ai.WrapError(ai.ErrCodeStream, "failed to parse event", err)
+	}
+	return &event, nil
+}
+
+// newRequest creates an authorized request to the Dify API
+func (d *DifyClient) newRequest(ctx context.Context, method string, path string, body io.Reader, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.config.GetAPIEndpoint()+path, body)
+	if err != nil {
+		return nil, ai.WrapError(ai.ErrCodeInvalidRequest, "failed to create request", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+d.config.GetAPIKey())
+	return req, nil
+}
+
+// do sends the request and converts failures into AI errors
+func (d *DifyClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ai.WrapError(ai.ErrCodeConnection, "failed to send request", err)
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	var errResp errorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Message == "" {
+		errResp.Message = strings.TrimSpace(string(body))
+	}
+	return nil, newAPIError(resp.StatusCode, errResp.Code, errResp.Message)
+}
+
+// newAPIError classifies a Dify error by its HTTP status and error code
+func newAPIError(status int, code string, message string) *ai.Error {
+	errCode := ai.ErrCodeUnavailable
+	switch {
+	case code == "provider_quota_exceeded":
+		errCode = ai.ErrCodeQuotaExceeded
+	case status == http.StatusNotFound && strings.Contains(strings.ToLower(message), "conversation"):
+		errCode = ai.ErrCodeConversationNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		errCode = ai.ErrCodeUnauthorized
+	case status == http.StatusTooManyRequests || code == "too_many_requests":
+		errCode = ai.ErrCodeRateLimited
+	case code == "provider_not_initialize" || code == "model_currently_not_support" || code == "app_unavailable":
+		errCode = ai.ErrCodeUnavailable
+	case status >= 400 && status < 500:
+		errCode = ai.ErrCodeInvalidRequest
+	}
+
+	if code != "" {
+		message = fmt.Sprintf("[%s] %s", code, message)
+	}
+	return &ai.Error{Code: errCode, Message: message, Status: status}
+}
+
+// Close implements ai.Provider interface
 func (d *DifyClient) Close() error {
-	// Nothing to clean up for Dify client
+	d.httpClient.CloseIdleConnections()
 	return nil
 }
@@ -0,0 +1,495 @@
+package dify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/config"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAPIKey = "app-test"
+
+// fakeDify is a local Dify server replaying scripted responses
+type fakeDify struct {
+	t         *testing.T
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter, req chatRequest)
+	requests  []chatRequest
+	uploads   []string
+}
+
+func newFakeDify(t *testing.T, responses ...func(w http.ResponseWriter, req chatRequest)) (*fakeDify, *DifyClient) {
+	f := &fakeDify{t: t, responses: responses}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	client := NewDifyClient(NewConfigAdapter(&config.ConfigImpl{
+		DifyAPIEndpoint: server.URL + "/v1",
+		DifyAPIKey:      testAPIKey,
+	}))
+	return f, client
+}
+
+func (f *fakeDify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testAPIKey {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Access token is invalid")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/files/upload":
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "no_file_uploaded", err.Error())
+			return
+		}
+		data, _ := io.ReadAll(file)
+		f.mu.Lock()
+		f.uploads = append(f.uploads, fmt.Sprintf("%s:%s:%s:%s", r.FormValue("user"), header.Filename, header.Header.Get("Content-Type"), data))
+		id := fmt.Sprintf("upload-%d", len(f.uploads))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(FileUploadResponse{ID: id, Name: header.Filename})
+
+	case "/v1/chat-messages":
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+			return
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		n := len(f.requests)
+		f.mu.Unlock()
+		if n > len(f.responses) {
+			f.t.Errorf("unexpected chat request #%d", n)
+			writeError(w, http.StatusInternalServerError, "internal_server_error", "unexpected request")
+			return
+		}
+		f.responses[n-1](w, req)
+
+	case "/v1/messages/m1/suggested":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method)
+			return
+		}
+		json.NewEncoder(w).Encode(suggestedResponse{Result: "success", Data: []string{"Dify支持哪些模型？", "如何部署Dify？", "Dify-" + r.URL.Query().Get("user")}})
+
+	case "/v1/audio-to-text":
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "no_audio_uploaded", err.Error())
+			return
+		}
+		if header.Header.Get("Content-Type") != "audio/wav" {
+			writeError(w, http.StatusUnsupportedMediaType, "unsupported_audio_type", header.Header.Get("Content-Type"))
+			return
+		}
+		data, _ := io.ReadAll(file)
+		json.NewEncoder(w).Encode(audioToTextResponse{Text: fmt.Sprintf("%s:%s:%s", r.FormValue("user"), header.Filename, data)})
+
+	case "/v1/messages/m2/suggested":
+		writeError(w, http.StatusBadRequest, "bad_request", "Suggested Questions Is Disabled.")
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDify) chatRequests() []chatRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]chatRequest(nil), f.requests...)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Code: code, Message: message, Status: status})
+}
+
+// sse replies with the events as a Dify event stream
+func sse(events ...string) func(w http.ResponseWriter, req chatRequest) {
+	return func(w http.ResponseWriter, req chatRequest) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, event := range events {
+			fmt.Fprint(w, event)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func httpError(status int, code string, message string) func(w http.ResponseWriter, req chatRequest) {
+	return func(w http.ResponseWriter, req chatRequest) {
+		writeError(w, status, code, message)
+	}
+}
+
+func data(event string) string {
+	return "data: " + event + "\n\n"
+}
+
+const (
+	answerHello = `{"event": "message", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "answer": "Hello", "created_at": 1705395332}`
+	answerWorld = `{"event": "message", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "answer": " world", "created_at": 1705395332}`
+	messageEnd  = `{"event": "message_end", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "metadata": {"usage": {"total_tokens": 12}, "retriever_resources": []}}`
+)
+
+func userMessage(content string, metadata map[string]string) []ai.Message {
+	return []ai.Message{{Role: "user", Content: content, Metadata: metadata}}
+}
+
+// collect runs StreamChat and returns the streamed events
+func collect(t *testing.T, client *DifyClient, messages []ai.Message) ([]ai.StreamEvent, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events := make(chan ai.StreamEvent)
+	done := make(chan error, 1)
+	go func() { done <- client.StreamChat(ctx, messages, events) }()
+
+	var got []ai.StreamEvent
+	for {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case err := <-done:
+			return got, err
+		}
+	}
+}
+
+// deltas returns the answer chunks of the events
+func deltas(events []ai.StreamEvent) []string {
+	var chunks []string
+	for _, event := range events {
+		if delta, ok := event.(ai.DeltaEvent); ok {
+			chunks = append(chunks, delta.Text)
+		}
+	}
+	return chunks
+}
+
+func TestStreamChatContract(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []func(w http.ResponseWriter, req chatRequest)
+		want      []string
+		wantErr   error
+		requests  int
+	}{
+		{
+			name:      "message events",
+			responses: []func(http.ResponseWriter, chatRequest){sse(data(answerHello), data(answerWorld), data(messageEnd))},
+			want:      []string{"Hello", " world"},
+			requests:  1,
+		},
+		{
+			name: "agent messages with pings",
+			responses: []func(http.ResponseWriter, chatRequest){sse(
+				"event: ping\n\n",
+				data(`{"event": "agent_thought", "id": "th1", "task_id": "t1", "message_id": "m1", "position": 1, "thought": "", "tool": "search", "tool_input": "{}", "conversation_id": "conv-1"}`),
+				data(`{"event": "agent_message", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "answer": "Found"}`),
+				data(`{"event": "message_file", "id": "f1", "type": "image", "belongs_to": "assistant", "url": "https://example.com/a.png", "conversation_id": "conv-1"}`),
+				data(`{"event": "agent_message", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "answer": " it"}`),
+				data(messageEnd),
+			)},
+			want:     []string{"Found", " it"},
+			requests: 1,
+		},
+		{
+			name:      "error event",
+			responses: []func(http.ResponseWriter, chatRequest){sse(data(answerHello), data(`{"event": "error", "task_id": "t1", "message_id": "m1", "status": 400, "code": "provider_quota_exceeded", "message": "Your quota has been exhausted"}`))},
+			want:      []string{"Hello"},
+			wantErr:   ai.ErrQuotaExceeded,
+			requests:  1,
+		},
+		{
+			name: "retries rate limits before streaming",
+			responses: []func(http.ResponseWriter, chatRequest){
+				httpError(http.StatusTooManyRequests, "too_many_requests", "Too many requests"),
+				sse(data(answerHello), data(messageEnd)),
+			},
+			want:     []string{"Hello"},
+			requests: 2,
+		},
+		{
+			name: "gives up after max retries",
+			responses: []func(http.ResponseWriter, chatRequest){
+				httpError(http.StatusServiceUnavailable, "", "upstream unavailable"),
+				httpError(http.StatusServiceUnavailable, "", "upstream unavailable"),
+				httpError(http.StatusServiceUnavailable, "", "upstream unavailable"),
+			},
+			wantErr:  ai.ErrUnavailable,
+			requests: MaxRetries + 1,
+		},
+		{
+			name:      "does not retry invalid requests",
+			responses: []func(http.ResponseWriter, chatRequest){httpError(http.StatusBadRequest, "invalid_param", "query is required")},
+			wantErr:   ai.ErrInvalidRequest,
+			requests:  1,
+		},
+		{
+			name:      "does not retry after streaming",
+			responses: []func(http.ResponseWriter, chatRequest){sse(data(answerHello))},
+			want:      []string{"Hello"},
+			wantErr:   &ai.Error{Code: ai.ErrCodeStream},
+			requests:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeDify(t, tt.responses...)
+			events, err := collect(t, client, userMessage("hi", map[string]string{"user_id": "u1", "session_id": "s1"}))
+			got := deltas(events)
+
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("StreamChat() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StreamChat() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StreamChat() chunks = %q, want %q", got, tt.want)
+			}
+			if n := len(fake.chatRequests()); n != tt.requests {
+				t.Errorf("chat requests = %d, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestStreamChatEvents(t *testing.T) {
+	_, client := newFakeDify(t, sse(
+		data(`{"event": "agent_thought", "id": "th1", "task_id": "t1", "message_id": "m1", "position": 1, "thought": "先搜索一下", "observation": "", "tool": "", "tool_input": "", "conversation_id": "conv-1"}`),
+		data(`{"event": "agent_thought", "id": "th1", "task_id": "t1", "message_id": "m1", "position": 1, "thought": "先搜索一下", "observation": "", "tool": "search", "tool_input": "{\"query\": \"dify\"}", "conversation_id": "conv-1"}`),
+		data(`{"event": "agent_thought", "id": "th1", "task_id": "t1", "message_id": "m1", "position": 1, "thought": "", "observation": "Dify is an LLM app platform", "tool": "search", "tool_input": "{\"query\": \"dify\"}", "conversation_id": "conv-1"}`),
+		data(`{"event": "agent_message", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "answer": "Dify是LLM应用平台"}`),
+		data(`{"event": "message_file", "id": "f1", "type": "image", "belongs_to": "assistant", "url": "https://example.com/a.png", "conversation_id": "conv-1"}`),
+		data(`{"event": "message_replace", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "answer": "replaced"}`),
+		data(`{"event": "message_end", "task_id": "t1", "message_id": "m1", "conversation_id": "conv-1", "metadata": {`+
+			`"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15, "total_price": "0.0001", "currency": "USD", "latency": 1.5}, `+
+			`"retriever_resources": [{"position": 1, "dataset_id": "ds1", "dataset_name": "产品手册", "document_id": "doc1", "document_name": "介绍.md", "segment_id": "seg1", "score": 0.92, "content": "Dify是一个开源的LLM应用开发平台"}, `+
+			`{"position": 2, "dataset_id": "ds2", "dataset_name": "官网", "document_id": "doc2", "document_name": "首页", "segment_id": "seg2", "score": 0.5, "content": "Dify", "doc_metadata": {"source_url": "https://dify.ai"}}]}}`),
+	))
+
+	got, err := collect(t, client, userMessage("什么是Dify", nil))
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	want := []ai.StreamEvent{
+		ai.ThoughtEvent{ID: "th1", Position: 1, Thought: "先搜索一下"},
+		ai.ThoughtEvent{ID: "th1", Position: 1, Thought: "先搜索一下"},
+		ai.ToolCallEvent{ID: "th1", Position: 1, Tool: "search", Input: `{"query": "dify"}`},
+		ai.ToolCallEvent{ID: "th1", Position: 1, Tool: "search", Input: `{"query": "dify"}`, Observation: "Dify is an LLM app platform"},
+		ai.DeltaEvent{Text: "Dify是LLM应用平台"},
+		ai.FileEvent{ID: "f1", Type: "image", URL: "https://example.com/a.png", BelongsTo: "assistant"},
+		ai.ReplaceEvent{Text: "replaced"},
+		ai.UsageEvent{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, TotalPrice: "0.0001", Currency: "USD", Latency: 1.5},
+		ai.CitationEvent{Position: 1, DatasetID: "ds1", DatasetName: "产品手册", DocumentID: "doc1", DocumentName: "介绍.md", SegmentID: "seg1", Content: "Dify是一个开源的LLM应用开发平台", Score: 0.92},
+		ai.CitationEvent{Position: 2, DatasetID: "ds2", DatasetName: "官网", DocumentID: "doc2", DocumentName: "首页", SegmentID: "seg2", Content: "Dify", Score: 0.5, URL: "https://dify.ai"},
+		ai.FinalEvent{MessageID: "m1", ConversationID: "conv-1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamChat() events =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestStreamChatRequest(t *testing.T) {
+	fake, client := newFakeDify(t, sse(data(answerHello), data(messageEnd)))
+	messages := []ai.Message{
+		{Role: "system", Content: "你是一名翻译"},
+		{
+			Role:     "user",
+			Content:  "描述图片",
+			Metadata: map[string]string{"user_id": "oc_chat", "session_id": "oc_chat", "conversation_id": "conv-0"},
+			Files: []ai.File{
+				{Type: ai.FileTypeImage, Name: "a.png", MimeType: "image/png", Data: []byte("png")},
+				{Type: ai.FileTypeDocument, Name: "b.pdf", UploadID: "uploaded-before"},
+			},
+		},
+	}
+	if _, err := collect(t, client, messages); err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+
+	requests := fake.chatRequests()
+	if len(requests) != 1 {
+		t.Fatalf("chat requests = %d, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.Query != "描述图片" || req.User != "oc_chat" || req.ConversationID != "conv-0" || req.ResponseMode != "streaming" {
+		t.Errorf("request = %+v, want query, user, conversation and streaming mode from the last message", req)
+	}
+	if history, _ := req.Inputs["history"].(string); !strings.Contains(history, "你是一名翻译") {
+		t.Errorf("inputs.history = %v, want the previous messages", req.Inputs["history"])
+	}
+
+	wantFiles := []map[string]interface{}{
+		{"type": "image", "transfer_method": "local_file", "upload_file_id": "upload-1"},
+		{"type": "document", "transfer_method": "local_file", "upload_file_id": "uploaded-before"},
+	}
+	if !reflect.DeepEqual(req.Files, wantFiles) {
+		t.Errorf("files = %v, want %v", req.Files, wantFiles)
+	}
+	if want := []string{"oc_chat:a.png:image/png:png"}; !reflect.DeepEqual(fake.uploads, want) {
+		t.Errorf("uploads = %v, want %v", fake.uploads, want)
+	}
+}
+
+func TestStreamChatConversation(t *testing.T) {
+	fake, client := newFakeDify(t,
+		sse(data(answerHello), data(messageEnd)),
+		httpError(http.StatusNotFound, "not_found", "Conversation Not Exists."),
+		sse(data(strings.ReplaceAll(answerHello, "conv-1", "conv-2")), data(strings.ReplaceAll(messageEnd, "conv-1", "conv-2"))),
+		sse(data(answerHello), data(messageEnd)),
+	)
+	finalConversation := func(events []ai.StreamEvent) string {
+		for _, e := range events {
+			if final, ok := e.(ai.FinalEvent); ok {
+				return final.ConversationID
+			}
+		}
+		return ""
+	}
+
+	// 首轮对话开启新会话，会话ID随FinalEvent返回
+	events, err := collect(t, client, userMessage("first", map[string]string{"user_id": "u1", "session_id": "s1"}))
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	if got := finalConversation(events); got != "conv-1" {
+		t.Fatalf("final conversation = %q, want conv-1", got)
+	}
+
+	// 会话失效时去掉会话ID重新开始
+	events, err = collect(t, client, userMessage("second", map[string]string{"user_id": "u1", "session_id": "s1", "conversation_id": "conv-1"}))
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	if got := finalConversation(events); got != "conv-2" {
+		t.Errorf("final conversation = %q, want conv-2", got)
+	}
+
+	// 清除会话后消息不再带会话ID，不能延续之前的Dify会话
+	if _, err := collect(t, client, userMessage("after clear", map[string]string{"user_id": "u1", "session_id": "s1"})); err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+
+	requests := fake.chatRequests()
+	if len(requests) != 4 {
+		t.Fatalf("chat requests = %d, want 4", len(requests))
+	}
+	var got []string
+	for _, req := range requests {
+		got = append(got, req.ConversationID)
+	}
+	if want := []string{"", "conv-1", "", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("conversation IDs = %q, want %q", got, want)
+	}
+}
+
+func TestStreamChatSystemPrompt(t *testing.T) {
+	fake, client := newFakeDify(t,
+		sse(data(answerHello), data(messageEnd)),
+		sse(data(answerHello), data(messageEnd)),
+	)
+	role := ai.Message{Role: "system", Content: "你是一名翻译"}
+
+	// 新会话的首条消息带上角色设定
+	if _, err := collect(t, client, []ai.Message{role, {Role: "user", Content: "hello", Metadata: map[string]string{"user_id": "u1"}}}); err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	// 会话已记住角色设定，后续消息不再重复
+	if _, err := collect(t, client, []ai.Message{role, {Role: "user", Content: "world", Metadata: map[string]string{"user_id": "u1", "conversation_id": "conv-1"}}}); err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+
+	requests := fake.chatRequests()
+	if len(requests) != 2 {
+		t.Fatalf("chat requests = %d, want 2", len(requests))
+	}
+	if want := "你是一名翻译\n\nhello"; requests[0].Query != want {
+		t.Errorf("first query = %q, want %q", requests[0].Query, want)
+	}
+	if requests[1].Query != "world" {
+		t.Errorf("second query = %q, want world", requests[1].Query)
+	}
+}
+
+func TestStreamChatUnauthorized(t *testing.T) {
+	_, client := newFakeDify(t)
+	client.config = NewConfigAdapter(&config.ConfigImpl{
+		DifyAPIEndpoint: client.config.GetAPIEndpoint(),
+		DifyAPIKey:      "wrong",
+	})
+
+	_, err := collect(t, client, userMessage("hi", nil))
+	if !errors.Is(err, ai.ErrUnauthorized) {
+		t.Fatalf("StreamChat() error = %v, want ErrUnauthorized", err)
+	}
+	var aiErr *ai.Error
+	if !errors.As(err, &aiErr) || aiErr.Status != http.StatusUnauthorized {
+		t.Errorf("StreamChat() error = %#v, want status 401", err)
+	}
+}
+
+func TestSuggestedQuestions(t *testing.T) {
+	_, client := newFakeDify(t)
+	ctx := context.Background()
+
+	got, err := client.SuggestedQuestions(ctx, "oc_chat", "m1")
+	if err != nil {
+		t.Fatalf("SuggestedQuestions() error = %v", err)
+	}
+	if want := []string{"Dify支持哪些模型？", "如何部署Dify？", "Dify-oc_chat"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SuggestedQuestions() = %q, want %q", got, want)
+	}
+
+	// 应用未开启下一步问题建议
+	if _, err := client.SuggestedQuestions(ctx, "oc_chat", "m2"); !errors.Is(err, ai.ErrInvalidRequest) {
+		t.Errorf("SuggestedQuestions() error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"https://api.dify.ai", "https://api.dify.ai/", "https://api.dify.ai/v1", "https://api.dify.ai/v1/"} {
+		adapter := NewConfigAdapter(&config.ConfigImpl{DifyAPIEndpoint: endpoint})
+		if got := adapter.GetAPIEndpoint(); got != "https://api.dify.ai/v1" {
+			t.Errorf("GetAPIEndpoint() of %q = %q, want https://api.dify.ai/v1", endpoint, got)
+		}
+	}
+}
+
+func TestAudioToText(t *testing.T) {
+	_, client := newFakeDify(t)
+	ctx := context.Background()
+
+	got, err := client.AudioToText(ctx, "ou_1", ai.File{Type: ai.FileTypeAudio, Name: "voice.wav", MimeType: "audio/wav", Data: []byte("RIFF")})
+	if err != nil {
+		t.Fatalf("AudioToText() error = %v", err)
+	}
+	if want := "ou_1:voice.wav:RIFF"; got != want {
+		t.Errorf("AudioToText() = %q, want %q", got, want)
+	}
+
+	// 错误按Dify错误码分类
+	_, err = client.AudioToText(ctx, "ou_1", ai.File{Type: ai.FileTypeAudio, Name: "voice.ogg", MimeType: "audio/ogg", Data: []byte("OggS")})
+	var aiErr *ai.Error
+	if !errors.As(err, &aiErr) || aiErr.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("AudioToText() error = %v, want a 415 AI error", err)
+	}
+}
@@ -0,0 +1,91 @@
+package dify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"start-feishubot/services/ai"
+)
+
+// FileUploadResponse is the response of /files/upload
+type FileUploadResponse struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Extension string `json:"extension"`
+	MimeType  string `json:"mime_type"`
+}
+
+// UploadFile uploads a file to Dify and returns its upload file ID
+func (d *DifyClient) UploadFile(ctx context.Context, userID string, file ai.File) (string, error) {
+	body, contentType, err := newFileForm(userID, file)
+	if err != nil {
+		return "", err
+	}
+	req, err := d.newRequest(ctx, http.MethodPost, "/files/upload", body, contentType)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.do(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file %s: %w", file.Name, err)
+	}
+	defer resp.Body.Close()
+
+	var result FileUploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", ai.WrapError(ai.ErrCodeStream, "failed to parse upload response", err)
+	}
+	log.Printf("Uploaded file %s to Dify: %s", file.Name, result.ID)
+	return result.ID, nil
+}
+
+// uploadFiles uploads the message files and builds the files field of chat-messages
+func (d *DifyClient) uploadFiles(ctx context.Context, userID string, files []ai.File) ([]map[string]interface{}, error) {
+	result := make([]map[string]interface{}, 0, len(files))
+	for _, file := range files {
+		id := file.UploadID
+		if id == "" {
+			var err error
+			if id, err = d.UploadFile(ctx, userID, file); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, map[string]interface{}{
+			"type":            file.Type,
+			"transfer_method": "local_file",
+			"upload_file_id":  id,
+		})
+	}
+	return result, nil
+}
+
+// newFileForm builds the multipart form of the file and user fields that the
+// file APIs of Dify accept, and returns it with its content type
+func newFileForm(userID string, file ai.File) (*bytes.Buffer, string, error) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, file.Name))
+	header.Set("Content-Type", file.MimeType)
+	fw, err := w.CreatePart(header)
+	if err != nil {
+		return nil, "", ai.WrapError(ai.ErrCodeInvalidRequest, "failed to create form file", err)
+	}
+	if _, err := fw.Write(file.Data); err != nil {
+		return nil, "", ai.WrapError(ai.ErrCodeInvalidRequest, "failed to write file data", err)
+	}
+	if err := w.WriteField("user", userID); err != nil {
+		return nil, "", ai.WrapError(ai.ErrCodeInvalidRequest, "failed to write user field", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", ai.WrapError(ai.ErrCodeInvalidRequest, "failed to close form", err)
+	}
+	return body, w.FormDataContentType(), nil
+}
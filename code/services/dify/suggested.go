@@ -0,0 +1,44 @@
+package dify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"start-feishubot/services/ai"
+)
+
+// suggestedResponse is the response of /messages/{message_id}/suggested
+type suggestedResponse struct {
+	Result string   `json:"result"`
+	Data   []string `json:"data"`
+}
+
+// SuggestedQuestions implements ai.Suggester interface.
+// The app must enable "suggested questions after answer", otherwise Dify
+// answers with an invalid request error.
+func (d *DifyClient) SuggestedQuestions(ctx context.Context, userID string, messageID string) ([]string, error) {
+	if messageID == "" {
+		return nil, ai.WrapError(ai.ErrCodeInvalidRequest, "empty message ID", nil)
+	}
+	if userID == "" {
+		userID = DefaultUser
+	}
+
+	path := "/messages/" + url.PathEscape(messageID) + "/suggested?user=" + url.QueryEscape(userID)
+	req, err := d.newRequest(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result suggestedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, ai.WrapError(ai.ErrCodeUnavailable, "failed to decode suggested questions", err)
+	}
+	return result.Data, nil
+}
@@ -0,0 +1,39 @@
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+)
+
+// BotInfo contains the identity of the bot
+type BotInfo struct {
+	AppName string `json:"app_name"`
+	OpenID  string `json:"open_id"`
+}
+
+// GetBotInfo fetches the bot's own identity
+func GetBotInfo(ctx context.Context, client *lark.Client) (*BotInfo, error) {
+	resp, err := client.Get(ctx, "/open-apis/bot/v3/info", nil, larkcore.AccessTokenTypeTenant)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Code int      `json:"code"`
+		Msg  string   `json:"msg"`
+		Bot  *BotInfo `json:"bot"`
+	}
+	if err := json.Unmarshal(resp.RawBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse bot info: %v", err)
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("failed to get bot info: [%d] %s", result.Code, result.Msg)
+	}
+	if result.Bot == nil || result.Bot.OpenID == "" {
+		return nil, fmt.Errorf("empty bot info")
+	}
+	return result.Bot, nil
+}
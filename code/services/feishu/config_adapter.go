@@ -1,6 +1,7 @@
 package feishu
 
 import (
+	"net/http"
 	"start-feishubot/services/config"
 	lark "github.com/larksuite/oapi-sdk-go/v3"
 )
@@ -11,11 +12,15 @@ type ConfigAdapter struct {
 	client *lark.Client
 }
 
-// NewConfigAdapter creates a new config adapter
-func NewConfigAdapter(config config.Config) *ConfigAdapter {
+// NewConfigAdapter creates a new config adapter, opts configure the Feishu client
+func NewConfigAdapter(config config.Config, opts ...lark.ClientOptionFunc) *ConfigAdapter {
+	opts = append([]lark.ClientOptionFunc{
+		lark.WithHttpClient(&limitedHTTPClient{client: http.DefaultClient}),
+	}, opts...)
 	client := lark.NewClient(
 		config.GetFeishuAppID(),
 		config.GetFeishuAppSecret(),
+		opts...,
 	)
 	return &ConfigAdapter{
 		config: config,
@@ -0,0 +1,113 @@
+package feishu
+
+import (
+	"context"
+	"fmt"
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"start-feishubot/services/core"
+)
+
+// MessageReader reads existing messages through the IM API
+type MessageReader struct {
+	config *ConfigAdapter
+}
+
+// NewMessageReader creates a new message reader
+func NewMessageReader(config *ConfigAdapter) *MessageReader {
+	return &MessageReader{
+		config: config,
+	}
+}
+
+// GetMessage implements core.MessageReader interface
+func (r *MessageReader) GetMessage(ctx context.Context, messageID string) (*core.FetchedMessage, error) {
+	items, err := r.getMessageItems(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return toFetchedMessage(items[0]), nil
+}
+
+// GetMergeForward implements core.MessageReader interface
+func (r *MessageReader) GetMergeForward(ctx context.Context, messageID string) ([]*core.FetchedMessage, error) {
+	items, err := r.getMessageItems(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 合并转发消息的查询结果包含其本身和所有子消息，只保留直接子消息
+	messages := make([]*core.FetchedMessage, 0, len(items))
+	for _, item := range items {
+		if item.UpperMessageId == nil || *item.UpperMessageId != messageID {
+			continue
+		}
+		messages = append(messages, toFetchedMessage(item))
+	}
+	return messages, nil
+}
+
+// GetUserName implements core.MessageReader interface
+func (r *MessageReader) GetUserName(ctx context.Context, openID string) (string, error) {
+	req := larkcontact.NewGetUserReqBuilder().
+		UserId(openID).
+		UserIdType("open_id").
+		Build()
+
+	resp, err := r.config.GetLarkClient().Contact.User.Get(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success() {
+		return "", fmt.Errorf("failed to get user: [%d] %s", resp.Code, resp.Msg)
+	}
+	if resp.Data == nil || resp.Data.User == nil || resp.Data.User.Name == nil {
+		return "", fmt.Errorf("user %s not found", openID)
+	}
+	return *resp.Data.User.Name, nil
+}
+
+// getMessageItems gets a message and, for merge_forward messages, its sub-messages
+func (r *MessageReader) getMessageItems(ctx context.Context, messageID string) ([]*larkim.Message, error) {
+	req := larkim.NewGetMessageReqBuilder().
+		MessageId(messageID).
+		Build()
+
+	resp, err := r.config.GetLarkClient().Im.Message.Get(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, fmt.Errorf("failed to get message: [%d] %s", resp.Code, resp.Msg)
+	}
+	if resp.Data == nil || len(resp.Data.Items) == 0 {
+		return nil, fmt.Errorf("message %s not found", messageID)
+	}
+	return resp.Data.Items, nil
+}
+
+// toFetchedMessage converts an IM API message
+func toFetchedMessage(item *larkim.Message) *core.FetchedMessage {
+	msg := &core.FetchedMessage{}
+	if item.MessageId != nil {
+		msg.MessageID = *item.MessageId
+	}
+	if item.MsgType != nil {
+		msg.MsgType = *item.MsgType
+	}
+	if item.CreateTime != nil {
+		msg.CreateTime = *item.CreateTime
+	}
+	if item.Body != nil && item.Body.Content != nil {
+		msg.Content = *item.Body.Content
+	}
+	if item.Sender != nil {
+		if item.Sender.Id != nil {
+			msg.SenderID = *item.Sender.Id
+		}
+		if item.Sender.SenderType != nil {
+			msg.SenderType = *item.Sender.SenderType
+		}
+	}
+	return msg
+}
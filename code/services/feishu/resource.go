@@ -0,0 +1,156 @@
+package feishu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"start-feishubot/services/core"
+	"time"
+)
+
+// Resource types of the message resource API
+const (
+	ResourceTypeImage = "image"
+	ResourceTypeFile  = "file"
+)
+
+// ResourceDownloader downloads the images and files attached to messages
+type ResourceDownloader struct {
+	config *ConfigAdapter
+}
+
+// NewResourceDownloader creates a new resource downloader
+func NewResourceDownloader(config *ConfigAdapter) *ResourceDownloader {
+	return &ResourceDownloader{
+		config: config,
+	}
+}
+
+// DownloadResource implements core.ResourceDownloader interface
+func (d *ResourceDownloader) DownloadResource(ctx context.Context, messageID string, fileKey string, resourceType string, maxSize int64) (*core.MessageResource, error) {
+	log.Printf("[Feishu] Downloading %s %s of message %s", resourceType, fileKey, messageID)
+	startTime := time.Now()
+
+	// SDK会把整个响应读入内存，由limitedHTTPClient在读取时限制大小
+	ctx = context.WithValue(ctx, maxBodySizeKey{}, maxSize)
+
+	req := larkim.NewGetMessageResourceReqBuilder().
+		MessageId(messageID).
+		FileKey(fileKey).
+		Type(resourceType).
+		Build()
+
+	resp, err := d.config.GetLarkClient().Im.MessageResource.Get(ctx, req)
+	if errors.Is(err, core.ErrResourceTooLarge) {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", core.ErrResourceTooLarge, fileKey, maxSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, fmt.Errorf("failed to download resource: [%d] %s", resp.Code, resp.Msg)
+	}
+	if resp.File == nil {
+		return nil, fmt.Errorf("empty resource %s", fileKey)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.File, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource: %v", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", core.ErrResourceTooLarge, fileKey, maxSize)
+	}
+
+	mimeType := http.DetectContentType(data)
+	name := resp.FileName
+	if name == "" {
+		name = fileKey + resourceExtension(mimeType, resourceType)
+	}
+	log.Printf("[Feishu] Downloaded %s (%d bytes) in %d ms", name, len(data), time.Since(startTime).Milliseconds())
+	return &core.MessageResource{
+		Name:     name,
+		MimeType: mimeType,
+		Data:     data,
+	}, nil
+}
+
+// preferredExtensions are the extensions of common content types, for which
+// mime.ExtensionsByType lists several extensions or none
+var preferredExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/ogg": ".opus", // 飞书语音为Ogg封装的Opus
+	"audio/mpeg":      ".mp3",
+	"video/mp4":       ".mp4",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// resourceExtension returns the file name extension of a resource without
+// file name, from its content type or else its resource type
+func resourceExtension(mimeType string, resourceType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err == nil {
+		if ext, ok := preferredExtensions[mediaType]; ok {
+			return ext
+		}
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			return exts[0]
+		}
+	}
+	if resourceType == ResourceTypeImage {
+		return ".png"
+	}
+	return ""
+}
+
+// maxBodySizeKey is the request context key of the response size limit
+type maxBodySizeKey struct{}
+
+// limitedHTTPClient is the HTTP client of the Feishu SDK, it fails requests
+// whose response exceeds the size limit of their context before the SDK
+// buffers the response
+type limitedHTTPClient struct {
+	client larkcore.HttpClient
+}
+
+func (c *limitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	maxSize, ok := req.Context().Value(maxBodySizeKey{}).(int64)
+	if !ok {
+		return resp, nil
+	}
+	if resp.ContentLength > maxSize {
+		resp.Body.Close()
+		return nil, core.ErrResourceTooLarge
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: maxSize}
+	return resp, nil
+}
+
+// limitedBody fails reading once more than remaining bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return 0, core.ErrResourceTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,54 @@
+package feishu
+
+import (
+	"context"
+	"errors"
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	"net/http"
+	"net/http/httptest"
+	"start-feishubot/services/config"
+	"start-feishubot/services/core"
+	"strings"
+	"testing"
+)
+
+func TestDownloadResourceSizeLimit(t *testing.T) {
+	data := strings.Repeat("a", 2048)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "tenant_access_token") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"code":0,"msg":"ok","tenant_access_token":"t-test","expire":7200}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/file_chunked") {
+			// 分块传输，响应不带Content-Length
+			w.Write([]byte(data[:1024]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(data[1024:]))
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	downloader := NewResourceDownloader(NewConfigAdapter(&config.ConfigImpl{
+		FeishuAppID:     "cli_test",
+		FeishuAppSecret: "secret",
+	}, lark.WithOpenBaseUrl(server.URL)))
+	ctx := context.Background()
+
+	resource, err := downloader.DownloadResource(ctx, "om_1", "file_1", ResourceTypeFile, 2048)
+	if err != nil {
+		t.Fatalf("DownloadResource() error = %v", err)
+	}
+	if string(resource.Data) != data {
+		t.Errorf("downloaded %d bytes, want %d", len(resource.Data), len(data))
+	}
+
+	for _, fileKey := range []string{"file_1", "file_chunked"} {
+		_, err = downloader.DownloadResource(ctx, "om_1", fileKey, ResourceTypeFile, 1024)
+		if !errors.Is(err, core.ErrResourceTooLarge) {
+			t.Errorf("DownloadResource(%s) error = %v, want ErrResourceTooLarge", fileKey, err)
+		}
+	}
+}
@@ -2,19 +2,43 @@ package openai
 
 import (
 	"fmt"
-	"start-feishubot/initialization"
+	"os"
 	"testing"
 )
 
-func TestCompletions(t *testing.T) {
-	config := initialization.LoadConfig("../../config.yaml")
+// newTestChatGPT creates a client of the real OpenAI API configured by the
+// OPENAI_API_KEY, OPENAI_API_URL and OPENAI_MODEL environment variables.
+// These tests call the API and are skipped without an API key.
+func newTestChatGPT(t *testing.T) *ChatGPT {
+	t.Helper()
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		t.Skip("OPENAI_API_KEY is not set")
+	}
+	apiUrl := os.Getenv("OPENAI_API_URL")
+	if apiUrl == "" {
+		apiUrl = "https://api.openai.com"
+	}
+	model := os.Getenv("OPENAI_MODEL")
+	if model == "" {
+		model = "gpt-3.5-turbo"
+	}
+	InitConfig(&Config{
+		OpenaiApiKeys:           []string{apiKey},
+		OpenaiApiUrl:            apiUrl,
+		OpenaiModel:             model,
+		OpenAIHttpClientTimeOut: 60,
+	})
+	return NewChatGPT()
+}
 
+func TestCompletions(t *testing.T) {
 	msgs := []Messages{
 		{Role: "system", Content: "你是一个专业的翻译官，负责中英文翻译。"},
 		{Role: "user", Content: "翻译这段话: The assistant messages help store prior responses. They can also be written by a developer to help give examples of desired behavior."},
 	}
 
-	gpt := NewChatGPT(*config)
+	gpt := newTestChatGPT(t)
 
 	resp, err := gpt.Completions(msgs)
 	if err != nil {
@@ -25,8 +49,7 @@ func TestCompletions(t *testing.T) {
 }
 
 func TestGenerateOneImage(t *testing.T) {
-	config := initialization.LoadConfig("../../config.yaml")
-	gpt := NewChatGPT(*config)
+	gpt := newTestChatGPT(t)
 	prompt := "a red apple"
 	size := "256x256"
 	imageURL, err := gpt.GenerateOneImage(prompt, size)
@@ -39,8 +62,7 @@ func TestGenerateOneImage(t *testing.T) {
 }
 
 func TestAudioToText(t *testing.T) {
-	config := initialization.LoadConfig("../../config.yaml")
-	gpt := NewChatGPT(*config)
+	gpt := newTestChatGPT(t)
 	audio := "./test_file/test.wav"
 	text, err := gpt.AudioToText(audio)
 	if err != nil {
@@ -54,8 +76,7 @@ func TestAudioToText(t *testing.T) {
 }
 
 func TestVariateOneImage(t *testing.T) {
-	config := initialization.LoadConfig("../../config.yaml")
-	gpt := NewChatGPT(*config)
+	gpt := newTestChatGPT(t)
 	image := "./test_file/img.png"
 	size := "256x256"
 	//compressionType, err := GetImageCompressionType(image)
@@ -81,8 +102,7 @@ func TestVariateOneImage(t *testing.T) {
 }
 
 func TestVariateOneImageWithJpg(t *testing.T) {
-	config := initialization.LoadConfig("../../config.yaml")
-	gpt := NewChatGPT(*config)
+	gpt := newTestChatGPT(t)
 	image := "./test_file/test.jpg"
 	size := "256x256"
 	compressionType, err := GetImageCompressionType(image)
@@ -110,8 +130,7 @@ func TestVariateOneImageWithJpg(t *testing.T) {
 
 // 余额接口已经被废弃
 func TestChatGPT_GetBalance(t *testing.T) {
-	config := initialization.LoadConfig("../../config.yaml")
-	gpt := NewChatGPT(*config)
+	gpt := newTestChatGPT(t)
 	balance, err := gpt.GetBalance()
 	if err != nil {
 		t.Errorf("TestChatGPT_GetBalance failed with error: %v", err)
@@ -23,6 +23,7 @@ const (
 	MaxTotalSessions  = 10000          // 总会话数限制
 	MaxMessageLength  = 4096           // 单条消息最大长度
 	MaxMessagesPerSession = 100        // 每个会话最大消息数
+	MaxDocumentsPerSession = 5         // 每个会话最大文档数
 	MemoryLimit       = int64(4 * 1024 * 1024 * 1024) // 4GB内存限制，总内存6GB
 )
 
@@ -55,12 +56,7 @@ var (
 // GetSessionCache 获取会话缓存单例
 func GetSessionCache() core.SessionCache {
 	once.Do(func() {
-		sessionServices = &SessionService{
-			cache:            cache.New(DefaultExpiration, CleanupInterval),
-			userSessionCount: make(map[string]int),
-			stats:           &core.SessionStats{},
-			userMessageIndex: make(map[string]map[string]*core.SessionMeta),
-		}
+		sessionServices = newSessionService()
 		
 		// 启动定期清理
 		go sessionServices.periodicCleanup()
@@ -71,6 +67,16 @@ func GetSessionCache() core.SessionCache {
 	return sessionServices
 }
 
+// newSessionService 创建会话服务，不启动后台清理
+func newSessionService() *SessionService {
+	return &SessionService{
+		cache:            cache.New(DefaultExpiration, CleanupInterval),
+		userSessionCount: make(map[string]int),
+		stats:            &core.SessionStats{},
+		userMessageIndex: make(map[string]map[string]*core.SessionMeta),
+	}
+}
+
 // GetMode 获取会话模式
 func (s *SessionService) GetMode(sessionId string) core.SessionMode {
 	s.mu.RLock()
@@ -119,12 +125,14 @@ func (s *SessionService) GetMessages(sessionId string) []ai.Message {
 	messages := make([]ai.Message, len(sessionMeta.Messages))
 	for i, msg := range sessionMeta.Messages {
 		messages[i] = msg
-		// 确保元数据存在
-		if messages[i].Metadata == nil {
-			messages[i].Metadata = make(map[string]string)
+		// 复制元数据，避免并发读取时写入缓存中共享的map
+		metadata := make(map[string]string, len(msg.Metadata)+1)
+		for k, v := range msg.Metadata {
+			metadata[k] = v
 		}
 		// 添加session_id到元数据
-		messages[i].Metadata["session_id"] = sessionId
+		metadata["session_id"] = sessionId
+		messages[i].Metadata = metadata
 	}
 	
 	return messages
@@ -135,7 +143,8 @@ func (s *SessionService) SetMessages(sessionId string, userId string, messages [
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// 检查是否为重复消息
+	// 检查是否为重复消息：同一条用户消息的回答只保存一次。
+	// 事件去重由msgCache完成，不写入此索引，不会拒绝首次保存
 	if s.isDuplicateMessageUnsafe(userId, messageId) {
 		return fmt.Errorf("duplicate message")
 	}
@@ -154,12 +163,6 @@ func (s *SessionService) SetMessages(sessionId string, userId string, messages [
 		return fmt.Errorf("too many messages: %d > %d", len(messages), MaxMessagesPerSession)
 	}
 
-	// 检查用户会话数限制
-	if s.userSessionCount[userId] >= MaxSessionsPerUser {
-		// 清理该用户最旧的会话
-		s.cleanOldestUserSession(userId)
-	}
-
 	// 计算会话大小
 	size := s.calculateSessionSize(messages)
 
@@ -173,44 +176,20 @@ func (s *SessionService) SetMessages(sessionId string, userId string, messages [
 		}
 	}
 
-	sessionContext, exists := s.cache.Get(sessionId)
-	var sessionMeta *core.SessionMeta
-	if !exists {
-		// 检查总会话数限制
-		if atomic.LoadInt32(&s.totalSessions) >= int32(MaxTotalSessions) {
-			s.forceCleanup()
-			if atomic.LoadInt32(&s.totalSessions) >= int32(MaxTotalSessions) {
-				return fmt.Errorf("max sessions limit exceeded")
-			}
-		}
-		
-		sessionMeta = &core.SessionMeta{
-			Messages:       messages,
-			UserId:         userId,
-			UpdatedAt:      time.Now(),
-			MessageNum:     len(messages),
-			Size:           size,
-			CardId:         cardId,
-			MessageId:      messageId,
-			ConversationID: conversationID,
-			CacheAddress:   cacheAddress,
-		}
-		atomic.AddInt32(&s.totalSessions, 1)
-		s.userSessionCount[userId]++
-	} else {
-		sessionMeta = sessionContext.(*core.SessionMeta)
-		atomic.AddInt64(&s.totalMemoryUsed, -sessionMeta.Size) // 减去旧大小
-		sessionMeta.Messages = messages
-		sessionMeta.UpdatedAt = time.Now()
-		sessionMeta.MessageNum = len(messages)
-		sessionMeta.Size = size
-		sessionMeta.CardId = cardId
-		sessionMeta.MessageId = messageId
-		sessionMeta.ConversationID = conversationID
-		sessionMeta.CacheAddress = cacheAddress
-	}
-
-	atomic.AddInt64(&s.totalMemoryUsed, size)
+	sessionMeta := s.getOrNewSessionUnsafe(sessionId)
+	if err := s.trackSessionUnsafe(sessionMeta, userId); err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.totalMemoryUsed, size-sessionMeta.Size) // 替换旧大小
+	sessionMeta.Messages = messages
+	sessionMeta.UpdatedAt = time.Now()
+	sessionMeta.MessageNum = len(messages)
+	sessionMeta.Size = size
+	sessionMeta.CardId = cardId
+	sessionMeta.MessageId = messageId
+	sessionMeta.ConversationID = conversationID
+	sessionMeta.CacheAddress = cacheAddress
+
 	s.cache.Set(sessionId, sessionMeta, DefaultExpiration)
 
 	// 更新用户消息索引
@@ -226,15 +205,14 @@ func (s *SessionService) SetMessages(sessionId string, userId string, messages [
 func (s *SessionService) Clear(sessionId string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.clearUnsafe(sessionId)
+}
 
+// clearUnsafe 内部使用的非线程安全版本
+func (s *SessionService) clearUnsafe(sessionId string) {
 	if item, exists := s.cache.Get(sessionId); exists {
 		meta := item.(*core.SessionMeta)
-		atomic.AddInt64(&s.totalMemoryUsed, -meta.Size)
-		atomic.AddInt32(&s.totalSessions, -1)
-		s.userSessionCount[meta.UserId]--
-		if s.userSessionCount[meta.UserId] <= 0 {
-			delete(s.userSessionCount, meta.UserId)
-		}
+		s.untrackSessionUnsafe(meta)
 
 		// 从用户消息索引中删除
 		if userMessages, ok := s.userMessageIndex[meta.UserId]; ok {
@@ -255,12 +233,10 @@ func (s *SessionService) ClearUserSessions(userId string) {
 	items := s.cache.Items()
 	for sessionId, item := range items {
 		if meta, ok := item.Object.(*core.SessionMeta); ok && meta.UserId == userId {
-			atomic.AddInt64(&s.totalMemoryUsed, -meta.Size)
-			atomic.AddInt32(&s.totalSessions, -1)
+			s.untrackSessionUnsafe(meta)
 			s.cache.Delete(sessionId)
 		}
 	}
-	delete(s.userSessionCount, userId)
 }
 
 // GetUserSessions 获取用户所有会话ID
@@ -282,19 +258,18 @@ func (s *SessionService) GetUserSessions(userId string) []string {
 func (s *SessionService) CleanExpiredSessions() int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.cleanExpiredSessionsUnsafe()
+}
 
+// cleanExpiredSessionsUnsafe 内部使用的非线程安全版本
+func (s *SessionService) cleanExpiredSessionsUnsafe() int {
 	count := 0
 	expiredTime := time.Now().Add(-DefaultExpiration)
 	items := s.cache.Items()
 	for sessionId, item := range items {
 		if meta, ok := item.Object.(*core.SessionMeta); ok {
 			if meta.UpdatedAt.Before(expiredTime) {
-				atomic.AddInt64(&s.totalMemoryUsed, -meta.Size)
-				atomic.AddInt32(&s.totalSessions, -1)
-				s.userSessionCount[meta.UserId]--
-				if s.userSessionCount[meta.UserId] <= 0 {
-					delete(s.userSessionCount, meta.UserId)
-				}
+				s.untrackSessionUnsafe(meta)
 				s.cache.Delete(sessionId)
 				count++
 			}
@@ -327,6 +302,55 @@ func (s *SessionService) calculateSessionSize(messages []ai.Message) int64 {
 	return int64(len(bytes))
 }
 
+// getOrNewSessionUnsafe returns the cached session, or a new session which is
+// not cached until the caller sets it
+func (s *SessionService) getOrNewSessionUnsafe(sessionId string) *core.SessionMeta {
+	if sessionContext, ok := s.cache.Get(sessionId); ok {
+		return sessionContext.(*core.SessionMeta)
+	}
+	return &core.SessionMeta{UpdatedAt: time.Now()}
+}
+
+// trackSessionUnsafe counts the session for the user the first time the user
+// saves content to it, sessions only holding settings are not counted.
+// untrackSessionUnsafe releases the counts.
+func (s *SessionService) trackSessionUnsafe(meta *core.SessionMeta, userId string) error {
+	if meta.UserId != "" || userId == "" {
+		return nil
+	}
+
+	// 检查用户会话数限制
+	if s.userSessionCount[userId] >= MaxSessionsPerUser {
+		// 清理该用户最旧的会话
+		s.cleanOldestUserSession(userId)
+	}
+	// 检查总会话数限制
+	if atomic.LoadInt32(&s.totalSessions) >= int32(MaxTotalSessions) {
+		s.forceCleanup()
+		if atomic.LoadInt32(&s.totalSessions) >= int32(MaxTotalSessions) {
+			return fmt.Errorf("max sessions limit exceeded")
+		}
+	}
+
+	meta.UserId = userId
+	atomic.AddInt32(&s.totalSessions, 1)
+	s.userSessionCount[userId]++
+	return nil
+}
+
+// untrackSessionUnsafe releases the memory and counts of a removed session
+func (s *SessionService) untrackSessionUnsafe(meta *core.SessionMeta) {
+	atomic.AddInt64(&s.totalMemoryUsed, -meta.Size)
+	if meta.UserId == "" {
+		return
+	}
+	atomic.AddInt32(&s.totalSessions, -1)
+	s.userSessionCount[meta.UserId]--
+	if s.userSessionCount[meta.UserId] <= 0 {
+		delete(s.userSessionCount, meta.UserId)
+	}
+}
+
 func (s *SessionService) cleanOldestUserSession(userId string) {
 	var oldestSession string
 	var oldestTime time.Time
@@ -340,13 +364,14 @@ func (s *SessionService) cleanOldestUserSession(userId string) {
 		}
 	}
 	if oldestSession != "" {
-		s.Clear(oldestSession)
+		s.clearUnsafe(oldestSession)
 	}
 }
 
+// forceCleanup 需持有写锁
 func (s *SessionService) forceCleanup() {
 	// 首先清理过期会话
-	s.CleanExpiredSessions()
+	s.cleanExpiredSessionsUnsafe()
 	
 	// 如果还需要清理，按最后访问时间清理
 	if atomic.LoadInt64(&s.totalMemoryUsed) > MemoryThresholdCleanup {
@@ -373,7 +398,7 @@ func (s *SessionService) forceCleanup() {
 		// 清理最旧的20%会话
 		cleanCount := len(sessions) / 5
 		for i := 0; i < cleanCount; i++ {
-			s.Clear(sessions[i].id)
+			s.clearUnsafe(sessions[i].id)
 		}
 	}
 }
@@ -405,6 +430,77 @@ func (s *SessionService) SetMsg(sessionId string, msg []ai.Message) {
 	s.cache.Set(sessionId, sessionMeta, DefaultExpiration)
 }
 
+// AddDocument 添加会话文档，超出上限时移除最早的文档
+func (s *SessionService) AddDocument(sessionId string, userId string, doc ai.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 只保存上传ID，不保存文件内容
+	doc.Data = nil
+
+	sessionMeta := s.getOrNewSessionUnsafe(sessionId)
+	if err := s.trackSessionUnsafe(sessionMeta, userId); err != nil {
+		return err
+	}
+	sessionMeta.UpdatedAt = time.Now()
+	sessionMeta.Documents = append(sessionMeta.Documents, doc)
+	if len(sessionMeta.Documents) > MaxDocumentsPerSession {
+		sessionMeta.Documents = sessionMeta.Documents[len(sessionMeta.Documents)-MaxDocumentsPerSession:]
+	}
+	s.cache.Set(sessionId, sessionMeta, DefaultExpiration)
+	return nil
+}
+
+// GetDocuments 获取会话文档
+func (s *SessionService) GetDocuments(sessionId string) []ai.File {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return nil
+	}
+	documents := sessionContext.(*core.SessionMeta).Documents
+	result := make([]ai.File, len(documents))
+	copy(result, documents)
+	return result
+}
+
+// AddUsage 累加会话的token用量
+func (s *SessionService) AddUsage(sessionId string, usage core.TokenUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &core.SessionMeta{
+			UpdatedAt: time.Now(),
+			Usage:     usage,
+		}
+		s.cache.Set(sessionId, sessionMeta, DefaultExpiration)
+		return
+	}
+	sessionMeta := sessionContext.(*core.SessionMeta)
+	sessionMeta.UpdatedAt = time.Now()
+	sessionMeta.Usage.Answers += usage.Answers
+	sessionMeta.Usage.PromptTokens += usage.PromptTokens
+	sessionMeta.Usage.CompletionTokens += usage.CompletionTokens
+	sessionMeta.Usage.TotalTokens += usage.TotalTokens
+	s.cache.Set(sessionId, sessionMeta, DefaultExpiration)
+}
+
+// GetUsage 获取会话的token用量
+func (s *SessionService) GetUsage(sessionId string) core.TokenUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return core.TokenUsage{}
+	}
+	return sessionContext.(*core.SessionMeta).Usage
+}
+
 func (s *SessionService) monitorMemory() {
 	ticker := time.NewTicker(time.Minute)
 	for range ticker.C {
@@ -414,7 +510,9 @@ func (s *SessionService) monitorMemory() {
 		// 如果总内存使用超过限制的80%，触发清理
 		if uint64(m.Alloc) > uint64(MemoryThresholdWarn) {
 			log.Printf("Memory usage high (%.2f MB), triggering cleanup", float64(m.Alloc)/1024/1024)
+			s.mu.Lock()
 			s.forceCleanup()
+			s.mu.Unlock()
 		}
 	}
 }
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"start-feishubot/services/ai"
+	"testing"
+)
+
+func TestSessionAccounting(t *testing.T) {
+	s := newSessionService()
+	doc := ai.File{Type: ai.FileTypeDocument, Name: "a.pdf", UploadID: "file-1"}
+	messages := []ai.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+
+	// 只保存设置的会话不计数
+	s.SetMode("settings", "")
+	// 先上传文档再提问的会话只计数一次
+	if err := s.AddDocument("doc_first", "ou_1", doc); err != nil {
+		t.Fatalf("AddDocument() error = %v", err)
+	}
+	if err := s.SetMessages("doc_first", "ou_1", messages, "card_1", "om_1", "", ""); err != nil {
+		t.Fatalf("SetMessages() error = %v", err)
+	}
+	if err := s.SetMessages("messages_first", "ou_1", messages, "card_2", "om_2", "", ""); err != nil {
+		t.Fatalf("SetMessages() error = %v", err)
+	}
+	if err := s.AddDocument("messages_first", "ou_1", doc); err != nil {
+		t.Fatalf("AddDocument() error = %v", err)
+	}
+	if stats := s.GetStats(); stats.TotalSessions != 2 || stats.ActiveUsers != 1 {
+		t.Fatalf("stats = %+v, want 2 sessions of 1 user", stats)
+	}
+
+	for _, sessionId := range []string{"settings", "doc_first", "messages_first"} {
+		s.Clear(sessionId)
+	}
+	if stats := s.GetStats(); stats.TotalSessions != 0 || stats.ActiveUsers != 0 || stats.TotalMemoryUsedMB != 0 {
+		t.Errorf("stats after clear = %+v, want no sessions", stats)
+	}
+}
+
+func TestSessionLimitPerUser(t *testing.T) {
+	s := newSessionService()
+	doc := ai.File{Type: ai.FileTypeDocument, Name: "a.pdf", UploadID: "file-1"}
+
+	// 超出上限时清理最旧的会话，不会因重复加锁而阻塞
+	for i := 0; i <= MaxSessionsPerUser; i++ {
+		if err := s.AddDocument(fmt.Sprintf("session_%d", i), "ou_1", doc); err != nil {
+			t.Fatalf("AddDocument() error = %v", err)
+		}
+	}
+	if got := len(s.GetUserSessions("ou_1")); got != MaxSessionsPerUser {
+		t.Errorf("user has %d sessions, want %d", got, MaxSessionsPerUser)
+	}
+	if stats := s.GetStats(); stats.TotalSessions != MaxSessionsPerUser {
+		t.Errorf("stats = %+v, want %d sessions", stats, MaxSessionsPerUser)
+	}
+}
+
+func TestSetMessagesDuplicate(t *testing.T) {
+	s := newSessionService()
+	messages := []ai.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+
+	if err := s.SetMessages("session", "ou_1", messages, "card_1", "om_1", "conv_1", ""); err != nil {
+		t.Fatalf("SetMessages() error = %v", err)
+	}
+	// 同一条消息的回答只保存一次
+	if err := s.SetMessages("session", "ou_1", messages, "card_1", "om_1", "conv_1", ""); err == nil {
+		t.Error("SetMessages() of the same message succeeded, want duplicate message error")
+	}
+	// 同一会话的下一条消息正常保存
+	next := append(messages, ai.Message{Role: "user", Content: "bye"}, ai.Message{Role: "assistant", Content: "bye"})
+	if err := s.SetMessages("session", "ou_1", next, "card_2", "om_2", "conv_1", ""); err != nil {
+		t.Fatalf("SetMessages() of the next message error = %v", err)
+	}
+	if got := s.GetMessages("session"); len(got) != 4 {
+		t.Errorf("session has %d messages, want 4", len(got))
+	}
+}
@@ -0,0 +1,36 @@
+package stt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"start-feishubot/services/ai"
+	"start-feishubot/services/dify"
+)
+
+// DifyTranscriber transcribes audio with the audio-to-text API of the Dify app
+type DifyTranscriber struct {
+	client *dify.DifyClient
+}
+
+// NewDifyTranscriber creates a new Dify transcriber
+func NewDifyTranscriber(client *dify.DifyClient) *DifyTranscriber {
+	return &DifyTranscriber{
+		client: client,
+	}
+}
+
+// Transcribe implements core.Transcriber interface
+func (d *DifyTranscriber) Transcribe(ctx context.Context, userID string, wavPath string) (string, error) {
+	data, err := os.ReadFile(wavPath)
+	if err != nil {
+		return "", fmt.Errorf("reading audio file: %w", err)
+	}
+	return d.client.AudioToText(ctx, userID, ai.File{
+		Type:     ai.FileTypeAudio,
+		Name:     filepath.Base(wavPath),
+		MimeType: "audio/wav",
+		Data:     data,
+	})
+}
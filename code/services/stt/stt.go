@@ -0,0 +1,42 @@
+package stt
+
+import (
+	"fmt"
+	"start-feishubot/services/config"
+	"start-feishubot/services/core"
+	"start-feishubot/services/dify"
+	"start-feishubot/services/openai"
+)
+
+// Speech to text backends
+const (
+	BackendDify   = "dify"
+	BackendOpenAI = "openai"
+)
+
+// DefaultOpenaiApiUrl is used when the OpenAI API URL is not configured
+const DefaultOpenaiApiUrl = "https://api.openai.com"
+
+// NewTranscriber creates the transcriber of the configured backend, Dify by default
+func NewTranscriber(cfg config.Config) (core.Transcriber, error) {
+	switch cfg.GetSpeechToText() {
+	case "", BackendDify:
+		return NewDifyTranscriber(dify.NewDifyClient(dify.NewConfigAdapter(cfg))), nil
+	case BackendOpenAI:
+		if cfg.GetOpenaiApiKey() == "" {
+			return nil, fmt.Errorf("openai api key is required for speech to text")
+		}
+		apiUrl := cfg.GetOpenaiApiUrl()
+		if apiUrl == "" {
+			apiUrl = DefaultOpenaiApiUrl
+		}
+		openai.InitConfig(&openai.Config{
+			OpenaiApiKeys:           []string{cfg.GetOpenaiApiKey()},
+			OpenaiApiUrl:            apiUrl,
+			OpenAIHttpClientTimeOut: 60,
+		})
+		return NewWhisperTranscriber(openai.NewChatGPT()), nil
+	default:
+		return nil, fmt.Errorf("unknown speech to text backend: %s", cfg.GetSpeechToText())
+	}
+}
@@ -0,0 +1,39 @@
+package stt
+
+import (
+	"context"
+	"start-feishubot/services/openai"
+)
+
+// WhisperTranscriber transcribes audio with OpenAI Whisper
+type WhisperTranscriber struct {
+	gpt *openai.ChatGPT
+}
+
+// NewWhisperTranscriber creates a new Whisper transcriber
+func NewWhisperTranscriber(gpt *openai.ChatGPT) *WhisperTranscriber {
+	return &WhisperTranscriber{
+		gpt: gpt,
+	}
+}
+
+// Transcribe implements core.Transcriber interface
+func (w *WhisperTranscriber) Transcribe(ctx context.Context, userID string, wavPath string) (string, error) {
+	type result struct {
+		text string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		text, err := w.gpt.AudioToText(wavPath)
+		done <- result{text: text, err: err}
+	}()
+
+	// AudioToText不支持context，超时后直接返回
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.text, r.err
+	}
+}